@@ -0,0 +1,29 @@
+package main
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+)
+
+// adminToken (ADMIN_TOKEN) protege las rutas /admin; vacío las deshabilita
+var adminToken string
+
+func requireAdmin(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if adminToken == "" {
+			respondError(w, http.StatusForbidden, "API de administración deshabilitada")
+			return
+		}
+		if !isAdminRequest(r) {
+			respondError(w, http.StatusUnauthorized, "Token de administración inválido")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func isAdminRequest(r *http.Request) bool {
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return adminToken != "" && subtle.ConstantTimeCompare([]byte(token), []byte(adminToken)) == 1
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"database/sql"
+	"image"
+	"log"
+	"math"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+)
+
+type Histograms struct {
+	Luminance [256]int `json:"luminance"`
+	Red       [256]int `json:"red"`
+	Green     [256]int `json:"green"`
+	Blue      [256]int `json:"blue"`
+}
+
+type AnalysisResponse struct {
+	ID         string     `json:"id"`
+	Width      int        `json:"width"`
+	Height     int        `json:"height"`
+	Brightness float64    `json:"brightness"` // luminancia media, 0-255
+	Contrast   float64    `json:"contrast"`   // desviación estándar de la luminancia
+	Sharpness  float64    `json:"sharpness"`  // varianza del laplaciano
+	Histograms Histograms `json:"histograms"`
+}
+
+func analysisHandler(w http.ResponseWriter, r *http.Request) {
+	userID := chi.URLParam(r, "userId")
+	imageID := chi.URLParam(r, "id")
+
+	img, err := findImage(r.Context(), userID, imageID)
+	if err == sql.ErrNoRows {
+		respondError(w, http.StatusNotFound, "Imagen no encontrada")
+		return
+	}
+	if err != nil {
+		log.Printf("Error BD: %v", err)
+		respondError(w, http.StatusInternalServerError, "Error consultando BD")
+		return
+	}
+
+	decoded, err := decodeImage(r.Context(), img)
+	if err != nil {
+		log.Printf("Error decodificando imagen: %v", err)
+		respondDecodeError(w, err, "")
+		return
+	}
+
+	response := analyzeImage(decoded)
+	response.ID = img.ID
+	respondJSON(w, http.StatusOK, response)
+}
+
+// analyzeImage calcula histogramas, brillo, contraste y nitidez. La nitidez
+// es la varianza del laplaciano sobre la luminancia: valores bajos indican
+// una foto desenfocada o movida.
+func analyzeImage(img image.Image) AnalysisResponse {
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	result := AnalysisResponse{Width: width, Height: height}
+
+	lum := make([]float64, width*height)
+	var sum float64
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			r, g, b, _ := img.At(bounds.Min.X+x, bounds.Min.Y+y).RGBA()
+			r, g, b = r>>8, g>>8, b>>8
+			l := luminance(r, g, b)
+			lum[y*width+x] = l
+			sum += l
+
+			result.Histograms.Red[r]++
+			result.Histograms.Green[g]++
+			result.Histograms.Blue[b]++
+			result.Histograms.Luminance[int(math.Round(l))]++
+		}
+	}
+
+	pixels := float64(width * height)
+	if pixels == 0 {
+		return result
+	}
+	mean := sum / pixels
+
+	var sqDiff float64
+	for _, l := range lum {
+		sqDiff += (l - mean) * (l - mean)
+	}
+	result.Brightness = round2(mean)
+	result.Contrast = round2(math.Sqrt(sqDiff / pixels))
+	result.Sharpness = round2(laplacianVariance(lum, width, height))
+	return result
+}
+
+// laplacianVariance aplica el kernel de 4 vecinos sobre los píxeles interiores
+func laplacianVariance(lum []float64, width, height int) float64 {
+	if width < 3 || height < 3 {
+		return 0
+	}
+
+	n := float64((width - 2) * (height - 2))
+	var sum, sumSq float64
+	for y := 1; y < height-1; y++ {
+		for x := 1; x < width-1; x++ {
+			i := y*width + x
+			v := lum[i-width] + lum[i+width] + lum[i-1] + lum[i+1] - 4*lum[i]
+			sum += v
+			sumSq += v * v
+		}
+	}
+	mean := sum / n
+	return sumSq/n - mean*mean
+}
+
+func round2(v float64) float64 {
+	return math.Round(v*100) / 100
+}
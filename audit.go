@@ -0,0 +1,177 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+const (
+	auditImageDeleted = "image.deleted"
+	auditImagePurged  = "image.purged"
+
+	maxDeleteReasonLen = 500
+	maxAuditRows       = 500
+)
+
+// channelPattern valida X-Client-Channel (web, ios, support-console, ...)
+var channelPattern = regexp.MustCompile(`^[a-z0-9_-]{1,50}$`)
+
+// Actor es quién hizo una operación y por qué canal
+type Actor struct {
+	Subject string `json:"subject"`
+	Channel string `json:"channel"`
+}
+
+type AuditEntry struct {
+	ID        int64     `json:"id"`
+	Action    string    `json:"action"`
+	UserID    string    `json:"user_id"`
+	ImageID   string    `json:"image_id,omitempty"`
+	Actor     string    `json:"actor"`
+	Channel   string    `json:"channel"`
+	Reason    string    `json:"reason,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type TrashResponse struct {
+	UserID string  `json:"user_id"`
+	Total  int     `json:"total"`
+	Images []Image `json:"images"`
+}
+
+// requestActor identifica al autor de la petición. La API no autentica a
+// los usuarios finales: se registra el sujeto que informa el gateway en
+// X-Actor-ID o, en su defecto, "admin" con el token de admin o el dueño del
+// recurso. realIP ya descartó esos headers si no vienen de un proxy de
+// confianza ni con el token.
+func requestActor(r *http.Request, ownerID string) Actor {
+	actor := Actor{Subject: "user:" + ownerID, Channel: "api"}
+	if isAdminRequest(r) {
+		actor = Actor{Subject: "admin", Channel: "admin"}
+	}
+	if sub := strings.TrimSpace(r.Header.Get("X-Actor-ID")); sub != "" && len(sub) <= 150 {
+		actor.Subject = sub
+	}
+	if ch := strings.ToLower(r.Header.Get("X-Client-Channel")); channelPattern.MatchString(ch) {
+		actor.Channel = ch
+	}
+	return actor
+}
+
+// deleteReason lee el motivo de ?reason= o de un body {"reason": "..."}
+func deleteReason(r *http.Request) (string, error) {
+	reason := r.URL.Query().Get("reason")
+	if reason == "" && r.Body != nil {
+		var body struct {
+			Reason string `json:"reason"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil && err != io.EOF {
+			return "", fmt.Errorf("JSON inválido")
+		}
+		reason = body.Reason
+	}
+	reason = strings.TrimSpace(reason)
+	if len([]rune(reason)) > maxDeleteReasonLen {
+		return "", fmt.Errorf("reason excede %d caracteres", maxDeleteReasonLen)
+	}
+	return reason, nil
+}
+
+// recordAudit falla en silencio (con log) para no revertir una operación
+// ya hecha por no poder anotarla
+func recordAudit(ctx context.Context, action, userID, imageID string, actor Actor, reason string) {
+	query := `INSERT INTO audit_log (action, user_id, image_id, actor, channel, reason) VALUES (?, ?, ?, ?, ?, NULLIF(?, ''))`
+	if _, err := db.ExecContext(ctx, query, action, userID, imageID, actor.Subject, actor.Channel, reason); err != nil {
+		log.Printf("Error BD registrando auditoría %s de %s/%s: %v", action, userID, imageID, err)
+	}
+}
+
+// trashHandler lista las imágenes eliminadas (soft) del usuario con quién,
+// cuándo, por qué canal y por qué se eliminaron
+func trashHandler(w http.ResponseWriter, r *http.Request) {
+	userID := chi.URLParam(r, "userId")
+
+	query := `SELECT ` + imageColumns + `
+			  FROM images WHERE user_id = ? AND deleted_at IS NOT NULL ORDER BY deleted_at DESC`
+	rows, err := db.QueryContext(r.Context(), query, userID)
+	if err != nil {
+		log.Printf("Error BD: %v", err)
+		respondError(w, http.StatusInternalServerError, "Error consultando BD")
+		return
+	}
+	defer rows.Close()
+
+	images := make([]Image, 0)
+	for rows.Next() {
+		img, err := scanImage(rows)
+		if err != nil {
+			log.Printf("Error escaneando fila: %v", err)
+			continue
+		}
+		img.URL = fmt.Sprintf("/image/%s/%s", img.UserID, img.ID)
+		images = append(images, img)
+	}
+
+	respondJSON(w, http.StatusOK, TrashResponse{UserID: userID, Total: len(images), Images: images})
+}
+
+// auditLogHandler permite a soporte consultar el historial por usuario o
+// imagen (?user_id=, ?image_id=, ?limit=)
+func auditLogHandler(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	var clauses []string
+	var args []interface{}
+	if v := q.Get("user_id"); v != "" {
+		clauses = append(clauses, "user_id = ?")
+		args = append(args, v)
+	}
+	if v := q.Get("image_id"); v != "" {
+		clauses = append(clauses, "image_id = ?")
+		args = append(args, v)
+	}
+	if len(clauses) == 0 {
+		respondError(w, http.StatusBadRequest, "Indicar user_id o image_id")
+		return
+	}
+
+	limit := 100
+	if v := q.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 || n > maxAuditRows {
+			respondError(w, http.StatusBadRequest, fmt.Sprintf("limit debe estar entre 1 y %d", maxAuditRows))
+			return
+		}
+		limit = n
+	}
+
+	query := `SELECT id, action, user_id, COALESCE(image_id, ''), actor, channel, COALESCE(reason, ''), created_at
+			  FROM audit_log WHERE ` + strings.Join(clauses, " AND ") + ` ORDER BY id DESC LIMIT ?`
+	rows, err := db.QueryContext(r.Context(), query, append(args, limit)...)
+	if err != nil {
+		log.Printf("Error BD: %v", err)
+		respondError(w, http.StatusInternalServerError, "Error consultando BD")
+		return
+	}
+	defer rows.Close()
+
+	entries := make([]AuditEntry, 0)
+	for rows.Next() {
+		var e AuditEntry
+		if err := rows.Scan(&e.ID, &e.Action, &e.UserID, &e.ImageID, &e.Actor, &e.Channel, &e.Reason, &e.CreatedAt); err != nil {
+			log.Printf("Error escaneando fila: %v", err)
+			continue
+		}
+		entries = append(entries, e)
+	}
+	respondJSON(w, http.StatusOK, entries)
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+)
+
+// Backend es donde viven los originales. images.volume guarda el nombre
+// del backend y images.file_path la clave dentro de él: la ruta absoluta en
+// los volúmenes locales, la clave del objeto en S3.
+type Backend interface {
+	Name() string
+	// Key arma la clave de un archivo nuevo del usuario
+	Key(userID, filename string) string
+	// Put escribe el archivo completo y devuelve su tamaño y sha256 en hex
+	Put(ctx context.Context, key string, src io.Reader) (int64, string, error)
+	Get(ctx context.Context, key string) (io.ReadCloser, error)
+	Stat(ctx context.Context, key string) (BlobInfo, error)
+	// Delete no falla si el archivo ya no existe
+	Delete(ctx context.Context, key string) error
+}
+
+type BlobInfo struct {
+	Size int64
+}
+
+var errBlobNotFound = errors.New("archivo no encontrado")
+
+// backends incluye los volúmenes locales y, si está configurado, S3
+var backends = make(map[string]Backend)
+
+// uploadBackend (UPLOAD_BACKEND) es donde se escriben las subidas nuevas;
+// vacío reparte entre los volúmenes locales según espacio libre y peso
+var uploadBackend string
+
+// legacyBackend resuelve imágenes anteriores a la columna volume, que
+// guardan una ruta absoluta
+var legacyBackend Backend = &localBackend{}
+
+// registerBackends arma el registro a partir de los volúmenes ya leídos y
+// de la configuración de S3
+func registerBackends() error {
+	for _, v := range volumes {
+		backends[v.Name] = &localBackend{volume: v}
+	}
+	s3, err := s3BackendFromEnv()
+	if err != nil {
+		return err
+	}
+	if s3 != nil {
+		if _, ok := backends[s3.Name()]; ok {
+			return fmt.Errorf("el backend %s ya es un volumen local", s3.Name())
+		}
+		backends[s3.Name()] = s3
+		log.Printf("✅ Backend %s en s3://%s/%s", s3.name, s3.bucket, s3.prefix)
+	}
+
+	uploadBackend = os.Getenv("UPLOAD_BACKEND")
+	if uploadBackend != "" {
+		if _, ok := backends[uploadBackend]; !ok {
+			return fmt.Errorf("UPLOAD_BACKEND %s no es un backend configurado", uploadBackend)
+		}
+		log.Printf("✅ Subidas nuevas en el backend %s", uploadBackend)
+	}
+	return nil
+}
+
+// pickUploadBackend elige dónde escribir una subida nueva
+func pickUploadBackend() (Backend, error) {
+	if uploadBackend != "" {
+		return backends[uploadBackend], nil
+	}
+	volume, err := pickVolume()
+	if err != nil {
+		return nil, err
+	}
+	return backends[volume.Name], nil
+}
+
+func findBackend(name string) (Backend, bool) {
+	b, ok := backends[name]
+	return b, ok
+}
+
+// backendFor devuelve el backend donde está el original de img
+func backendFor(img Image) (Backend, error) {
+	if img.Volume == "" {
+		return legacyBackend, nil
+	}
+	if b, ok := backends[img.Volume]; ok {
+		return b, nil
+	}
+	return nil, fmt.Errorf("backend %s no configurado en esta réplica", img.Volume)
+}
+
+// openImage abre el original de img en su backend
+func openImage(ctx context.Context, img Image) (io.ReadCloser, error) {
+	b, err := backendFor(img)
+	if err != nil {
+		return nil, err
+	}
+	return b.Get(ctx, img.FilePath)
+}
+
+// isLocalImage indica si FilePath es una ruta del disco de esta réplica
+func isLocalImage(img Image) bool {
+	b, err := backendFor(img)
+	if err != nil {
+		return false
+	}
+	_, ok := b.(*localBackend)
+	return ok
+}
+
+// renditionDir es el cache de renditions de img: junto al original si es
+// local y, si no, en el primer volumen
+func renditionDir(img Image) string {
+	base := filepath.Dir(img.FilePath)
+	if !isLocalImage(img) {
+		base = filepath.Join(volumes[0].Path, img.UserID)
+	}
+	return filepath.Join(base, renditionsDir, img.ID)
+}
+
+// localBackend es un volumen de UPLOAD_VOLUMES
+type localBackend struct {
+	volume Volume
+}
+
+func (l *localBackend) Name() string { return l.volume.Name }
+
+func (l *localBackend) Key(userID, filename string) string {
+	return filepath.Join(l.volume.Path, userID, filename)
+}
+
+func (l *localBackend) Put(ctx context.Context, key string, src io.Reader) (int64, string, error) {
+	if err := os.MkdirAll(filepath.Dir(key), 0755); err != nil {
+		return 0, "", err
+	}
+	return writeFile(key, ctxReader{ctx, src})
+}
+
+func (l *localBackend) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	file, err := os.Open(key)
+	if os.IsNotExist(err) {
+		return nil, errBlobNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return file, nil
+}
+
+func (l *localBackend) Stat(ctx context.Context, key string) (BlobInfo, error) {
+	info, err := os.Stat(key)
+	if os.IsNotExist(err) {
+		return BlobInfo{}, errBlobNotFound
+	}
+	if err != nil {
+		return BlobInfo{}, err
+	}
+	return BlobInfo{Size: info.Size()}, nil
+}
+
+func (l *localBackend) Delete(ctx context.Context, key string) error {
+	if err := os.Remove(key); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// readChecksum calcula el sha256 en hex de todo lo que queda en r
+func readChecksum(r io.Reader) (string, error) {
+	hash := sha256.New()
+	if _, err := io.Copy(hash, r); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// Operaciones del changelog
+const (
+	changeCreated = "created"
+	changeUpdated = "updated"
+	changeDeleted = "deleted"
+
+	defaultChangesLimit = 500
+	maxChangesLimit     = 1000
+)
+
+type Change struct {
+	Seq       int64     `json:"seq"`
+	ImageID   string    `json:"image_id"`
+	Op        string    `json:"op"`
+	CreatedAt time.Time `json:"created_at"`
+	Image     *Image    `json:"image,omitempty"` // estado actual si sigue existiendo
+}
+
+type ChangesResponse struct {
+	UserID    string   `json:"user_id"`
+	Changes   []Change `json:"changes"`
+	NextSince int64    `json:"next_since"`
+	HasMore   bool     `json:"has_more"`
+}
+
+// recordChange anota una mutación en image_changes. Se llama dentro de la
+// transacción de la mutación para que no quede una sin la otra.
+// Los cambios internos (backfills, migración de volumen) no se anotan: no
+// alteran nada que el cliente sincronice.
+func recordChange(ctx context.Context, tx *sql.Tx, userID, imageID, op string) error {
+	return recordChanges(ctx, tx, userID, []string{imageID}, op)
+}
+
+// recordChanges es recordChange para muchas imágenes del mismo usuario, con
+// un INSERT por tramo
+func recordChanges(ctx context.Context, tx *sql.Tx, userID string, imageIDs []string, op string) error {
+	seq, err := reserveChangeSeqs(ctx, tx, userID, int64(len(imageIDs)))
+	if err != nil {
+		return err
+	}
+	for _, chunk := range chunkStrings(imageIDs, maxBulkTagIDs) {
+		values := make([]string, len(chunk))
+		args := make([]interface{}, 0, 4*len(chunk))
+		for i, id := range chunk {
+			values[i] = "(?, ?, ?, ?)"
+			args = append(args, seq, userID, id, op)
+			seq++
+		}
+		if _, err := tx.ExecContext(ctx, `INSERT INTO image_changes (seq, user_id, image_id, op) VALUES `+strings.Join(values, ", "), args...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// lockUserCounters toma la fila de user_counters del usuario hasta el final
+// de tx, creándola si hace falta. Serializa las mutaciones del usuario que
+// necesitan un orden: el changelog y el conteo de imágenes al subir.
+func lockUserCounters(ctx context.Context, tx *sql.Tx, userID string) (changeSeq int64, err error) {
+	if _, err := tx.ExecContext(ctx, `INSERT IGNORE INTO user_counters (user_id) VALUES (?)`, userID); err != nil {
+		return 0, err
+	}
+	err = tx.QueryRowContext(ctx, `SELECT change_seq FROM user_counters WHERE user_id = ? FOR UPDATE`, userID).Scan(&changeSeq)
+	return changeSeq, err
+}
+
+// reserveChangeSeqs reserva n seq consecutivos del usuario y devuelve el
+// primero. La fila queda bloqueada hasta el commit, así una transacción con
+// un seq mayor no puede confirmar antes que otra con uno menor y el cliente
+// nunca ve un hueco que después se llena.
+func reserveChangeSeqs(ctx context.Context, tx *sql.Tx, userID string, n int64) (int64, error) {
+	last, err := lockUserCounters(ctx, tx, userID)
+	if err != nil {
+		return 0, err
+	}
+	if _, err := tx.ExecContext(ctx, `UPDATE user_counters SET change_seq = ? WHERE user_id = ?`, last+n, userID); err != nil {
+		return 0, err
+	}
+	return last + 1, nil
+}
+
+// changesHandler devuelve los cambios posteriores a ?since= en orden de
+// secuencia. El cliente guarda next_since y lo manda en la siguiente
+// llamada; con has_more=true debe seguir pidiendo sin esperar.
+func changesHandler(w http.ResponseWriter, r *http.Request) {
+	userID := chi.URLParam(r, "userId")
+	q := r.URL.Query()
+
+	var since int64
+	if v := q.Get("since"); v != "" {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || n < 0 {
+			respondError(w, http.StatusBadRequest, "since debe ser un entero positivo")
+			return
+		}
+		since = n
+	}
+	limit := defaultChangesLimit
+	if v := q.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 || n > maxChangesLimit {
+			respondError(w, http.StatusBadRequest, fmt.Sprintf("limit debe estar entre 1 y %d", maxChangesLimit))
+			return
+		}
+		limit = n
+	}
+
+	// Se pide uno más para saber si quedan cambios
+	rows, err := db.QueryContext(r.Context(), `SELECT seq, image_id, op, created_at FROM image_changes
+		WHERE user_id = ? AND seq > ? ORDER BY seq LIMIT ?`, userID, since, limit+1)
+	if err != nil {
+		log.Printf("Error BD: %v", err)
+		respondError(w, http.StatusInternalServerError, "Error consultando BD")
+		return
+	}
+	changes := make([]Change, 0)
+	for rows.Next() {
+		var c Change
+		if err := rows.Scan(&c.Seq, &c.ImageID, &c.Op, &c.CreatedAt); err != nil {
+			log.Printf("Error escaneando fila: %v", err)
+			continue
+		}
+		changes = append(changes, c)
+	}
+	rows.Close()
+
+	response := ChangesResponse{UserID: userID, Changes: changes, NextSince: since}
+	if len(changes) > limit {
+		response.Changes, response.HasMore = changes[:limit], true
+	}
+
+	ids := make([]string, 0, len(response.Changes))
+	for _, c := range response.Changes {
+		ids = append(ids, c.ImageID)
+	}
+	if ids = uniqueStrings(ids); len(ids) > 0 {
+		found, err := findImagesByID(r.Context(), userID, ids)
+		if err != nil {
+			log.Printf("Error BD: %v", err)
+			respondError(w, http.StatusInternalServerError, "Error consultando BD")
+			return
+		}
+		for i := range response.Changes {
+			c := &response.Changes[i]
+			if img, ok := found[c.ImageID]; ok && c.Op != changeDeleted {
+				img.URL = fmt.Sprintf("/image/%s/%s", img.UserID, img.ID)
+				c.Image = &img
+			}
+		}
+	}
+	if n := len(response.Changes); n > 0 {
+		response.NextSince = response.Changes[n-1].Seq
+	}
+
+	respondJSON(w, http.StatusOK, response)
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Tipos de inconsistencia del reporte de -check
+const (
+	issueMissingFile      = "missing_file"
+	issueSizeMismatch     = "size_mismatch"
+	issueChecksumMismatch = "checksum_mismatch"
+	issueOrphanFile       = "orphan_file"
+	issueUnreadable       = "unreadable_file"
+)
+
+const checkBatchSize = 500
+
+type CheckIssue struct {
+	Type    string `json:"type"`
+	ImageID string `json:"image_id,omitempty"`
+	UserID  string `json:"user_id,omitempty"`
+	Path    string `json:"path"`
+	Detail  string `json:"detail,omitempty"`
+}
+
+type CheckReport struct {
+	CheckedAt time.Time      `json:"checked_at"`
+	Images    int            `json:"images"`
+	Files     int            `json:"files"`
+	Checksums bool           `json:"checksums_verified"`
+	OK        bool           `json:"ok"`
+	Counts    map[string]int `json:"counts"`
+	Issues    []CheckIssue   `json:"issues"`
+}
+
+func (rep *CheckReport) add(issue CheckIssue) {
+	rep.Issues = append(rep.Issues, issue)
+	rep.Counts[issue.Type]++
+}
+
+// runCheck cruza los registros de BD con los archivos de los volúmenes:
+// archivos faltantes, tamaños o checksums distintos a los registrados y
+// archivos que ningún registro referencia. Incluye imágenes borradas
+// lógicamente, que conservan su archivo.
+func runCheck(verifyChecksums bool) (CheckReport, error) {
+	rep := CheckReport{
+		CheckedAt: time.Now().UTC(),
+		Checksums: verifyChecksums,
+		Counts:    make(map[string]int),
+		Issues:    make([]CheckIssue, 0),
+	}
+	known := make(map[string]bool)
+
+	// Por tandas y con la consulta ya cerrada al verificar: hashear un
+	// volumen grande puede llevar horas y no debe retener una conexión
+	lastID := ""
+	for {
+		batch, err := checkBatch(lastID)
+		if err != nil {
+			return rep, err
+		}
+		if len(batch) == 0 {
+			break
+		}
+		for _, img := range batch {
+			rep.Images++
+			if isLocalImage(img) {
+				known[filepath.Clean(img.FilePath)] = true
+			}
+			checkImage(&rep, img, verifyChecksums)
+		}
+		lastID = batch[len(batch)-1].ID
+	}
+
+	// Las subidas en curso todavía no tienen registro en images
+	pending, err := db.Query(`SELECT file_path FROM pending_uploads`)
+	if err != nil {
+		return rep, err
+	}
+	defer pending.Close()
+	for pending.Next() {
+		var path string
+		if err := pending.Scan(&path); err != nil {
+			return rep, err
+		}
+		known[filepath.Clean(path)] = true
+	}
+	if err := pending.Err(); err != nil {
+		return rep, err
+	}
+
+	for _, v := range volumes {
+		err := filepath.WalkDir(v.Path, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				rep.add(CheckIssue{Type: issueUnreadable, Path: path, Detail: err.Error()})
+				return nil
+			}
+			if d.IsDir() {
+				if d.Name() == renditionsDir {
+					return filepath.SkipDir // caché derivable, no son originales
+				}
+				return nil
+			}
+			if strings.HasSuffix(path, partialSuffix) {
+				return nil // de eso se encarga cleanupLoop
+			}
+			rep.Files++
+			if !known[filepath.Clean(path)] {
+				rep.add(CheckIssue{Type: issueOrphanFile, Path: path})
+			}
+			return nil
+		})
+		if err != nil {
+			return rep, err
+		}
+	}
+
+	rep.OK = len(rep.Issues) == 0
+	return rep, nil
+}
+
+// checkBatch lee la siguiente tanda de imágenes por id, después de lastID
+func checkBatch(lastID string) ([]Image, error) {
+	rows, err := db.Query(`SELECT `+imageColumns+` FROM images WHERE id > ? ORDER BY id LIMIT ?`, lastID, checkBatchSize)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	batch := make([]Image, 0, checkBatchSize)
+	for rows.Next() {
+		img, err := scanImage(rows)
+		if err != nil {
+			return nil, err
+		}
+		batch = append(batch, img)
+	}
+	return batch, rows.Err()
+}
+
+// checkImage verifica el original en su backend, local o S3
+func checkImage(rep *CheckReport, img Image, verifyChecksums bool) {
+	ctx := context.Background()
+	b, err := backendFor(img)
+	if err != nil {
+		rep.add(CheckIssue{Type: issueUnreadable, ImageID: img.ID, UserID: img.UserID, Path: img.FilePath, Detail: err.Error()})
+		return
+	}
+	info, err := b.Stat(ctx, img.FilePath)
+	if errors.Is(err, errBlobNotFound) {
+		rep.add(CheckIssue{Type: issueMissingFile, ImageID: img.ID, UserID: img.UserID, Path: img.FilePath})
+		return
+	}
+	if err != nil {
+		rep.add(CheckIssue{Type: issueUnreadable, ImageID: img.ID, UserID: img.UserID, Path: img.FilePath, Detail: err.Error()})
+		return
+	}
+	if info.Size != img.SizeBytes {
+		rep.add(CheckIssue{Type: issueSizeMismatch, ImageID: img.ID, UserID: img.UserID, Path: img.FilePath,
+			Detail: formatSize(img.SizeBytes) + " registrados, " + formatSize(info.Size) + " almacenados"})
+	}
+
+	if !verifyChecksums || img.Checksum == "" {
+		return
+	}
+	sum, err := blobChecksum(ctx, b, img.FilePath)
+	if err != nil {
+		rep.add(CheckIssue{Type: issueUnreadable, ImageID: img.ID, UserID: img.UserID, Path: img.FilePath, Detail: err.Error()})
+		return
+	}
+	if sum != img.Checksum {
+		rep.add(CheckIssue{Type: issueChecksumMismatch, ImageID: img.ID, UserID: img.UserID, Path: img.FilePath,
+			Detail: "registrado " + img.Checksum + ", almacenado " + sum})
+	}
+}
+
+// printCheckReport escribe el reporte como JSON en stdout; los logs van a
+// stderr, así que la salida se puede procesar directamente
+func printCheckReport(rep CheckReport) {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(rep); err != nil {
+		log.Printf("Error escribiendo reporte: %v", err)
+	}
+}
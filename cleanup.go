@@ -0,0 +1,101 @@
+package main
+
+import (
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const partialSuffix = ".part"
+
+var (
+	staleFileAge    time.Duration // STALE_FILE_AGE
+	cleanupInterval time.Duration // CLEANUP_INTERVAL
+
+	cleanupRuns         = newCounter("image_api_cleanup_runs_total", "Pasadas de limpieza de archivos temporales.")
+	cleanupTempRemoved  = newCounter("image_api_cleanup_temp_files_removed_total", "Temporales de multipart eliminados.")
+	cleanupPartRemoved  = newCounter("image_api_cleanup_partial_files_removed_total", "Subidas parciales eliminadas.")
+	cleanupBytesRemoved = newCounter("image_api_cleanup_bytes_removed_total", "Bytes liberados por la limpieza.")
+	cleanupLastRemoved  = newGauge("image_api_cleanup_last_removed_files", "Archivos eliminados en la última pasada.")
+)
+
+func cleanupLoop() {
+	workerBeat("cleanup")
+	cleanupStaleFiles()
+
+	ticker := time.NewTicker(cleanupInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		workerBeat("cleanup")
+		cleanupStaleFiles()
+	}
+}
+
+// cleanupStaleFiles borra temporales de multipart y subidas parciales que
+// quedaron tras un crash/OOM. Solo toca archivos más viejos que
+// staleFileAge, así no interfiere con subidas en curso.
+func cleanupStaleFiles() {
+	cutoff := time.Now().Add(-staleFileAge)
+
+	// mime/multipart crea sus temporales como "multipart-*" en os.TempDir()
+	tempFiles, tempBytes := removeStale(os.TempDir(), cutoff, false, func(name string) bool {
+		return strings.HasPrefix(name, "multipart-")
+	})
+
+	var partFiles, partBytes int64
+	for _, v := range volumes {
+		n, b := removeStale(v.Path, cutoff, true, func(name string) bool {
+			return strings.HasSuffix(name, partialSuffix)
+		})
+		partFiles += n
+		partBytes += b
+	}
+
+	cleanupRuns.Add(1)
+	cleanupTempRemoved.Add(tempFiles)
+	cleanupPartRemoved.Add(partFiles)
+	cleanupBytesRemoved.Add(tempBytes + partBytes)
+	cleanupLastRemoved.Set(tempFiles + partFiles)
+
+	if tempFiles+partFiles > 0 {
+		log.Printf("✓ Limpieza: %d temporales y %d parciales eliminados (%d bytes)",
+			tempFiles, partFiles, tempBytes+partBytes)
+	}
+}
+
+// removeStale elimina los archivos de dir que cumplen match y son anteriores
+// a cutoff. Con recursive recorre los subdirectorios.
+func removeStale(dir string, cutoff time.Time, recursive bool, match func(string) bool) (files, bytes int64) {
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return nil // archivos que desaparecen durante el recorrido
+		}
+		if d.IsDir() {
+			if path != dir && !recursive {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !match(d.Name()) {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil || info.ModTime().After(cutoff) {
+			return nil
+		}
+		if err := os.Remove(path); err != nil {
+			log.Printf("Error eliminando %s: %v", path, err)
+			return nil
+		}
+		files++
+		bytes += info.Size()
+		return nil
+	})
+	if err != nil {
+		log.Printf("Error recorriendo %s: %v", dir, err)
+	}
+	return files, bytes
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"image"
+	"image/color"
+	"image/png"
+	"log"
+	"math"
+	"math/bits"
+	"net/http"
+)
+
+// compareSize es el lado máximo al que se normalizan ambas imágenes
+const compareSize = 256
+
+type CompareRequest struct {
+	UserID string `json:"user_id"`
+	ImageA string `json:"image_a"`
+	ImageB string `json:"image_b"`
+	Diff   bool   `json:"diff"` // incluir imagen de diferencias
+}
+
+type CompareResponse struct {
+	ImageA          string  `json:"image_a"`
+	ImageB          string  `json:"image_b"`
+	Similarity      float64 `json:"similarity"`    // 0-1, 1 = mismos píxeles
+	HashDistance    int     `json:"hash_distance"` // distancia Hamming del dHash (0-64)
+	DimensionsMatch bool    `json:"dimensions_match"`
+	DiffImage       string  `json:"diff_image,omitempty"` // PNG como data URI
+}
+
+// compareHandler compara dos imágenes del mismo usuario, p.ej. para
+// verificar que un arte re-subido coincide con el original aprobado
+func compareHandler(w http.ResponseWriter, r *http.Request) {
+	var req CompareRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondError(w, http.StatusBadRequest, "JSON inválido")
+		return
+	}
+	if req.UserID == "" || req.ImageA == "" || req.ImageB == "" {
+		respondError(w, http.StatusBadRequest, "user_id, image_a e image_b son requeridos")
+		return
+	}
+
+	decoded := make([]image.Image, 2)
+	for i, id := range []string{req.ImageA, req.ImageB} {
+		img, err := findImage(r.Context(), req.UserID, id)
+		if err == sql.ErrNoRows {
+			respondError(w, http.StatusNotFound, "Imagen no encontrada: "+id)
+			return
+		}
+		if err != nil {
+			log.Printf("Error BD: %v", err)
+			respondError(w, http.StatusInternalServerError, "Error consultando BD")
+			return
+		}
+		decoded[i], err = decodeImage(r.Context(), img)
+		if err != nil {
+			log.Printf("Error decodificando imagen: %v", err)
+			respondDecodeError(w, err, ": "+id)
+			return
+		}
+	}
+
+	a, b := decoded[0], decoded[1]
+	response := CompareResponse{
+		ImageA:          req.ImageA,
+		ImageB:          req.ImageB,
+		DimensionsMatch: a.Bounds().Size() == b.Bounds().Size(),
+		HashDistance:    bits.OnesCount64(dHash(a) ^ dHash(b)),
+	}
+
+	// Ambas se llevan al tamaño de A reducido, así B se compara sobre la
+	// misma grilla aunque se haya subido a otra resolución
+	width, height := fitWithin(a.Bounds().Dx(), a.Bounds().Dy(), compareSize, compareSize)
+	ra := resizeImage(a, width, height)
+	rb := resizeImage(b, width, height)
+
+	similarity, diff := pixelDiff(ra, rb, req.Diff)
+	response.Similarity = math.Round(similarity*10000) / 10000
+
+	if diff != nil {
+		var buf bytes.Buffer
+		if err := png.Encode(&buf, diff); err != nil {
+			log.Printf("Error codificando diff: %v", err)
+			respondError(w, http.StatusInternalServerError, "Error generando diff")
+			return
+		}
+		response.DiffImage = "data:image/png;base64," + base64.StdEncoding.EncodeToString(buf.Bytes())
+	}
+
+	respondJSON(w, http.StatusOK, response)
+}
+
+// pixelDiff devuelve 1 - diferencia absoluta media normalizada. Si se pide,
+// arma una imagen con A en gris tenue y las diferencias resaltadas en rojo.
+func pixelDiff(a, b *image.NRGBA, withImage bool) (float64, *image.NRGBA) {
+	bounds := a.Bounds()
+	var diffImg *image.NRGBA
+	if withImage {
+		diffImg = image.NewNRGBA(bounds)
+	}
+
+	var total float64
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			ca, cb := a.NRGBAAt(x, y), b.NRGBAAt(x, y)
+			d := (absDiff(ca.R, cb.R) + absDiff(ca.G, cb.G) + absDiff(ca.B, cb.B)) / 3
+			total += d
+
+			if diffImg != nil {
+				gray := uint8(luminance(uint32(ca.R), uint32(ca.G), uint32(ca.B))/4 + 160)
+				if d > 16 {
+					diffImg.SetNRGBA(x, y, color.NRGBA{R: 255, G: uint8(255 - d), B: uint8(255 - d), A: 255})
+				} else {
+					diffImg.SetNRGBA(x, y, color.NRGBA{R: gray, G: gray, B: gray, A: 255})
+				}
+			}
+		}
+	}
+
+	pixels := float64(bounds.Dx() * bounds.Dy())
+	if pixels == 0 {
+		return 1, diffImg
+	}
+	return 1 - total/pixels/255, diffImg
+}
+
+// dHash es un hash perceptual de 64 bits: compara cada píxel con su vecino
+// derecho sobre una versión de 9x8 en escala de grises
+func dHash(img image.Image) uint64 {
+	small := resizeImage(img, 9, 8)
+	var hash uint64
+	for y := 0; y < 8; y++ {
+		for x := 0; x < 8; x++ {
+			l, r := small.NRGBAAt(x, y), small.NRGBAAt(x+1, y)
+			if luminance(uint32(l.R), uint32(l.G), uint32(l.B)) > luminance(uint32(r.R), uint32(r.G), uint32(r.B)) {
+				hash |= 1 << uint(y*8+x)
+			}
+		}
+	}
+	return hash
+}
+
+func absDiff(a, b uint8) float64 {
+	if a > b {
+		return float64(a - b)
+	}
+	return float64(b - a)
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/go-chi/chi/v5/middleware"
+)
+
+// Envelope es la forma de toda respuesta JSON bajo /v1. Las rutas sin
+// prefijo mantienen las formas anteriores para no romper clientes.
+type Envelope struct {
+	Data       interface{}    `json:"data,omitempty"`
+	Error      *EnvelopeError `json:"error,omitempty"`
+	RequestID  string         `json:"request_id"`
+	Pagination *Pagination    `json:"pagination,omitempty"`
+}
+
+type EnvelopeError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+type Pagination struct {
+	Limit      int  `json:"limit"`
+	Offset     int  `json:"offset"`
+	Total      int  `json:"total"`
+	NextOffset *int `json:"next_offset,omitempty"`
+}
+
+func newPagination(limit, offset, total int) *Pagination {
+	p := &Pagination{Limit: limit, Offset: offset, Total: total}
+	if next := offset + limit; next < total {
+		p.NextOffset = &next
+	}
+	return p
+}
+
+// envelopeWriter marca la respuesta para que respondJSON y respondError
+// la envuelvan; el resto de los writers (timeout, logger) lo dejan pasar
+type envelopeWriter struct {
+	http.ResponseWriter
+	requestID string
+}
+
+func (ew *envelopeWriter) Unwrap() http.ResponseWriter { return ew.ResponseWriter }
+
+// Flush mantiene el streaming NDJSON bajo /v1
+func (ew *envelopeWriter) Flush() {
+	http.NewResponseController(ew.ResponseWriter).Flush()
+}
+
+func withEnvelope(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next.ServeHTTP(&envelopeWriter{ResponseWriter: w, requestID: middleware.GetReqID(r.Context())}, r)
+	})
+}
+
+// requestID asigna (o respeta el X-Request-Id entrante) y lo devuelve en
+// la respuesta para poder cruzarlo con los logs
+func requestID(next http.Handler) http.Handler {
+	return middleware.RequestID(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set(middleware.RequestIDHeader, middleware.GetReqID(r.Context()))
+		next.ServeHTTP(w, r)
+	}))
+}
+
+func envelopeOf(w http.ResponseWriter) *envelopeWriter {
+	for w != nil {
+		if ew, ok := w.(*envelopeWriter); ok {
+			return ew
+		}
+		u, ok := w.(interface{ Unwrap() http.ResponseWriter })
+		if !ok {
+			return nil
+		}
+		w = u.Unwrap()
+	}
+	return nil
+}
+
+// respondPage es respondJSON con datos de paginación, que solo se exponen
+// dentro del envelope
+func respondPage(w http.ResponseWriter, code int, v interface{}, page *Pagination) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	if ew := envelopeOf(w); ew != nil {
+		json.NewEncoder(w).Encode(Envelope{Data: v, RequestID: ew.requestID, Pagination: page})
+		return
+	}
+	json.NewEncoder(w).Encode(v)
+}
+
+// errorCode deriva el código del status: 404 -> NOT_FOUND
+func errorCode(status int) string {
+	text := http.StatusText(status)
+	if text == "" {
+		return "ERROR"
+	}
+	return strings.ToUpper(strings.NewReplacer(" ", "_", "-", "_", "'", "").Replace(text))
+}
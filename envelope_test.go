@@ -0,0 +1,69 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// Los errores de rutas que sirven binarios también salen en el sobre
+func TestTransformErrorUsesEnvelope(t *testing.T) {
+	handler := requestID(withEnvelope(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		serveImageOrTransform(w, r, Image{ID: "a", UserID: "u1"})
+	})))
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/v1/image/u1/a?w=0", nil))
+
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("status %d, se esperaba 400", w.Code)
+	}
+	var env Envelope
+	if err := json.Unmarshal(w.Body.Bytes(), &env); err != nil {
+		t.Fatalf("respuesta fuera del sobre: %q", w.Body.String())
+	}
+	if env.Error == nil || env.Error.Code != "BAD_REQUEST" || env.Error.Message == "" {
+		t.Fatalf("error inesperado: %+v", env.Error)
+	}
+	if env.RequestID == "" || env.RequestID != w.Header().Get("X-Request-Id") {
+		t.Fatalf("request_id %q no coincide con el header %q", env.RequestID, w.Header().Get("X-Request-Id"))
+	}
+}
+
+func TestRespondPageEnvelope(t *testing.T) {
+	handler := requestID(withEnvelope(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		respondPage(w, http.StatusOK, []string{"a", "b"}, newPagination(2, 0, 5))
+	})))
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/v1/images/u1", nil))
+
+	var env struct {
+		Data       []string    `json:"data"`
+		Pagination *Pagination `json:"pagination"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &env); err != nil {
+		t.Fatal(err)
+	}
+	if len(env.Data) != 2 || env.Pagination == nil || env.Pagination.Total != 5 {
+		t.Fatalf("sobre inesperado: %s", w.Body.String())
+	}
+	if env.Pagination.NextOffset == nil || *env.Pagination.NextOffset != 2 {
+		t.Fatalf("next_offset inesperado: %s", w.Body.String())
+	}
+}
+
+func TestErrorCode(t *testing.T) {
+	cases := map[int]string{
+		http.StatusNotFound:            "NOT_FOUND",
+		http.StatusUnprocessableEntity: "UNPROCESSABLE_ENTITY",
+		http.StatusTeapot:              "IM_A_TEAPOT",
+		599:                            "ERROR",
+	}
+	for status, want := range cases {
+		if got := errorCode(status); got != want {
+			t.Errorf("errorCode(%d) = %s, se esperaba %s", status, got, want)
+		}
+	}
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// maxFilenameBytes es el tamaño de images.filename (VARCHAR(255) en utf8mb4
+// cuenta caracteres, pero se acota en bytes para headers y ZIP)
+const maxFilenameBytes = 255
+
+const defaultFilename = "imagen"
+
+// sanitizeFilename normaliza el nombre original a NFC (macOS manda los
+// acentos descompuestos), reemplaza UTF-8 inválido, quita caracteres de
+// control y separadores de ruta, y lo recorta sin partir caracteres ni
+// perder la extensión
+func sanitizeFilename(name string) string {
+	name = norm.NFC.String(strings.ToValidUTF8(name, "\uFFFD"))
+	name = strings.Map(func(r rune) rune {
+		switch {
+		case r == '/' || r == '\\':
+			return '_'
+		case unicode.IsControl(r) || (unicode.Is(unicode.Cf, r) && r != '\u200d'):
+			return -1 // conservar ZWJ para emoji compuestos
+		}
+		return r
+	}, name)
+	name = strings.TrimSpace(name)
+
+	ext := filepath.Ext(name)
+	base := strings.TrimSuffix(name, ext)
+	if len(ext) > 16 {
+		base, ext = name, ""
+	}
+	if base == "" || base == "." || base == ".." {
+		base = defaultFilename
+	}
+	for len(base)+len(ext) > maxFilenameBytes {
+		_, size := utf8.DecodeLastRuneInString(base)
+		base = base[:len(base)-size]
+	}
+	return base + ext
+}
+
+// displayNames asigna nombres únicos dentro de un conjunto de nombres,
+// sufijando " (1)", " (2)"... antes de la extensión. La comparación ignora
+// mayúsculas porque así se comportan los sistemas de archivos de escritorio.
+type displayNames map[string]bool
+
+func (d displayNames) unique(name string) string {
+	ext := filepath.Ext(name)
+	base := strings.TrimSuffix(name, ext)
+	candidate := name
+	for i := 1; d[strings.ToLower(candidate)]; i++ {
+		candidate = fmt.Sprintf("%s (%d)%s", base, i, ext)
+	}
+	d[strings.ToLower(candidate)] = true
+	return candidate
+}
+
+// uniqueDisplayName elige el nombre visible de una imagen nueva entre los
+// de las demás imágenes no eliminadas del usuario. Se llama dentro de
+// journalCommit, bajo el lock de user_counters, y solo consulta los nombres
+// que pueden chocar: el mismo o "base (n).ext". El resultado se guarda en
+// images.display_name para que listados y export no recorran la biblioteca.
+func uniqueDisplayName(ctx context.Context, tx *sql.Tx, userID, filename string) (string, error) {
+	ext := filepath.Ext(filename)
+	base := strings.TrimSuffix(filename, ext)
+	rows, err := tx.QueryContext(ctx, `SELECT display_name FROM images
+		WHERE user_id = ? AND deleted_at IS NULL AND (display_name = ? OR display_name LIKE ?)`,
+		userID, filename, escapeLike(base)+" (%)"+escapeLike(ext))
+	if err != nil {
+		return "", err
+	}
+	defer rows.Close()
+
+	taken := make(displayNames)
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return "", err
+		}
+		taken[strings.ToLower(name)] = true
+	}
+	if err := rows.Err(); err != nil {
+		return "", err
+	}
+	return taken.unique(filename), nil
+}
+
+// escapeLike escapa los comodines de LIKE (el escape por defecto es \)
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
+
+// contentDisposition arma el header con un nombre ASCII de respaldo y el
+// nombre real en filename* (RFC 6266/5987)
+func contentDisposition(disposition, name string) string {
+	var fallback strings.Builder
+	for _, r := range norm.NFD.String(name) {
+		switch {
+		case r == '"' || r == '\\':
+			fallback.WriteRune('_')
+		case r >= 0x20 && r < 0x7f:
+			fallback.WriteRune(r)
+		case unicode.Is(unicode.Mn, r):
+			// acento separado por NFD: se descarta y queda la letra base
+		default:
+			fallback.WriteRune('_')
+		}
+	}
+	return fmt.Sprintf(`%s; filename="%s"; filename*=UTF-8''%s`, disposition, fallback.String(), rfc5987Escape(name))
+}
+
+// rfc5987Escape deja solo attr-char sin escapar
+func rfc5987Escape(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c < 0x80 && (unicode.IsLetter(rune(c)) || unicode.IsDigit(rune(c)) || strings.IndexByte("!#$&+-.^_`|~", c) >= 0) {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSanitizeFilename(t *testing.T) {
+	cases := []struct{ in, want string }{
+		{"cafe\u0301.jpg", "caf\u00e9.jpg"}, // NFD de macOS
+		{"../../etc/passwd", ".._.._etc_passwd"},
+		{"a\x00b\tc.png", "abc.png"},
+		{"  foto.png  ", "foto.png"},
+		{".png", "imagen.png"},
+		{"", "imagen"},
+		{"\xff\xfe.gif", "\uFFFD.gif"}, // una secuencia inválida, un solo reemplazo
+		{"👨‍👩‍👧.webp", "👨‍👩‍👧.webp"},
+	}
+	for _, c := range cases {
+		if got := sanitizeFilename(c.in); got != c.want {
+			t.Errorf("sanitizeFilename(%q) = %q, se esperaba %q", c.in, got, c.want)
+		}
+	}
+}
+
+func TestSanitizeFilenameKeepsExtensionWhenTruncating(t *testing.T) {
+	name := sanitizeFilename(strings.Repeat("ñ", 200) + ".jpeg")
+	if len(name) > maxFilenameBytes {
+		t.Fatalf("nombre de %d bytes", len(name))
+	}
+	if !strings.HasSuffix(name, "ñ.jpeg") {
+		t.Fatalf("se perdió la extensión o se partió un carácter: %q", name)
+	}
+}
+
+func TestDisplayNamesUnique(t *testing.T) {
+	d := make(displayNames)
+	got := []string{
+		d.unique("foto.jpg"),
+		d.unique("FOTO.jpg"),
+		d.unique("foto (1).jpg"),
+		d.unique("foto.jpg"),
+	}
+	want := []string{"foto.jpg", "FOTO (1).jpg", "foto (1) (1).jpg", "foto (2).jpg"}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("nombres %q, se esperaba %q", got, want)
+		}
+	}
+}
+
+func TestEscapeLike(t *testing.T) {
+	if got, want := escapeLike(`50%_off\x`), `50\%\_off\\x`; got != want {
+		t.Fatalf("escapeLike = %q, se esperaba %q", got, want)
+	}
+}
+
+func TestContentDisposition(t *testing.T) {
+	got := contentDisposition("attachment", `año "1".png`)
+	want := `attachment; filename="ano _1_.png"; filename*=UTF-8''a%C3%B1o%20%221%22.png`
+	if got != want {
+		t.Fatalf("contentDisposition = %s, se esperaba %s", got, want)
+	}
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// aspectTolerance es la diferencia relativa admitida al filtrar por aspect
+const aspectTolerance = 0.01
+
+const (
+	defaultPageLimit = 100
+	maxPageLimit     = 1000
+)
+
+// parseListFilters traduce los filtros de query string a condiciones SQL
+// (cada una precedida por " AND ") y sus argumentos
+func parseListFilters(q url.Values) (string, []interface{}, error) {
+	var clauses []string
+	var args []interface{}
+
+	bounds := []struct {
+		param string
+		cond  string
+	}{
+		{"min_width", "width >= ?"},
+		{"max_width", "width <= ?"},
+		{"min_height", "height >= ?"},
+		{"max_height", "height <= ?"},
+	}
+	for _, b := range bounds {
+		v := q.Get(b.param)
+		if v == "" {
+			continue
+		}
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return "", nil, fmt.Errorf("%s debe ser un entero positivo", b.param)
+		}
+		clauses = append(clauses, b.cond)
+		args = append(args, n)
+	}
+
+	switch q.Get("orientation") {
+	case "":
+	case "portrait":
+		clauses = append(clauses, "height > width")
+	case "landscape":
+		clauses = append(clauses, "width > height")
+	case "square":
+		clauses = append(clauses, "width = height AND width > 0")
+	default:
+		return "", nil, fmt.Errorf("orientation debe ser portrait, landscape o square")
+	}
+
+	if v := q.Get("album_id"); v != "" {
+		clauses = append(clauses, "album_id = ?")
+		args = append(args, v)
+	}
+	for _, tag := range q["tag"] {
+		clauses = append(clauses, "id IN (SELECT image_id FROM image_tags WHERE tag = ?)")
+		args = append(args, strings.ToLower(strings.TrimSpace(tag)))
+	}
+
+	if v := q.Get("aspect"); v != "" {
+		ratio, err := parseAspect(v)
+		if err != nil {
+			return "", nil, err
+		}
+		clauses = append(clauses, "height > 0 AND ABS(width / height - ?) <= ?")
+		args = append(args, ratio, ratio*aspectTolerance)
+	}
+
+	if len(clauses) == 0 {
+		return "", nil, nil
+	}
+	return " AND " + strings.Join(clauses, " AND "), args, nil
+}
+
+// parsePagination lee ?limit=&offset=; limit 0 significa sin paginar
+func parsePagination(q url.Values, defaultLimit int) (int, int, error) {
+	limit, offset := defaultLimit, 0
+	if v := q.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 || n > maxPageLimit {
+			return 0, 0, fmt.Errorf("limit debe estar entre 1 y %d", maxPageLimit)
+		}
+		limit = n
+	}
+	if v := q.Get("offset"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return 0, 0, fmt.Errorf("offset debe ser un entero positivo")
+		}
+		if limit == 0 {
+			return 0, 0, fmt.Errorf("offset requiere limit")
+		}
+		offset = n
+	}
+	return limit, offset, nil
+}
+
+// parseAspect acepta "16:9" o "1.777"
+func parseAspect(v string) (float64, error) {
+	if w, h, ok := strings.Cut(v, ":"); ok {
+		width, errW := strconv.ParseFloat(w, 64)
+		height, errH := strconv.ParseFloat(h, 64)
+		if errW != nil || errH != nil || width <= 0 || height <= 0 {
+			return 0, fmt.Errorf("aspect inválido, usar formato ancho:alto")
+		}
+		return width / height, nil
+	}
+	ratio, err := strconv.ParseFloat(v, 64)
+	if err != nil || ratio <= 0 {
+		return 0, fmt.Errorf("aspect inválido, usar formato ancho:alto")
+	}
+	return ratio, nil
+}
@@ -6,6 +6,10 @@ require (
 	github.com/go-chi/chi/v5 v5.2.3
 	github.com/go-sql-driver/mysql v1.9.3
 	github.com/google/uuid v1.6.0
+	github.com/skip2/go-qrcode v0.0.0-20200617195104-da1b6568686e
+	golang.org/x/image v0.34.0
+	golang.org/x/text v0.32.0
+	golang.org/x/time v0.15.0
 )
 
 require filippo.io/edwards25519 v1.1.0 // indirect
@@ -0,0 +1,248 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Estados de cada dependencia en /health?deep=true
+const (
+	checkOK       = "ok"
+	checkDegraded = "degraded"
+	checkFailed   = "failed"
+)
+
+// minFreeBytes (HEALTH_MIN_FREE_BYTES): por debajo, el volumen se informa
+// como degradado
+var minFreeBytes int64
+
+type CheckResult struct {
+	Status    string      `json:"status"`
+	LatencyMS int64       `json:"latency_ms,omitempty"`
+	Error     string      `json:"error,omitempty"`
+	Details   interface{} `json:"details,omitempty"`
+}
+
+type VolumeCheck struct {
+	Name      string `json:"name"`
+	Status    string `json:"status"`
+	FreeBytes uint64 `json:"free_bytes"`
+	LatencyMS int64  `json:"latency_ms"`
+	Error     string `json:"error,omitempty"`
+}
+
+type WorkerCheck struct {
+	Name     string     `json:"name"`
+	Status   string     `json:"status"`
+	Interval string     `json:"interval"`
+	LastBeat *time.Time `json:"last_beat,omitempty"`
+}
+
+type DeepHealthResponse struct {
+	Status  string                 `json:"status"`
+	Service string                 `json:"service"`
+	Checks  map[string]CheckResult `json:"checks"`
+}
+
+// Registro de latidos de los loops de fondo. Un worker que no late en dos
+// intervalos (más un margen) se considera caído.
+type workerState struct {
+	interval   time.Duration
+	registered time.Time
+	lastBeat   time.Time
+}
+
+var (
+	workers   = make(map[string]*workerState)
+	workersMu sync.Mutex
+)
+
+const workerGrace = time.Minute
+
+func registerWorker(name string, interval time.Duration) {
+	workersMu.Lock()
+	defer workersMu.Unlock()
+	workers[name] = &workerState{interval: interval, registered: time.Now()}
+}
+
+func workerBeat(name string) {
+	workersMu.Lock()
+	defer workersMu.Unlock()
+	if w, ok := workers[name]; ok {
+		w.lastBeat = time.Now()
+	}
+}
+
+func deepHealthHandler(w http.ResponseWriter, r *http.Request) {
+	checks := map[string]CheckResult{
+		"db":      checkDB(r),
+		"schema":  checkSchema(),
+		"storage": checkStorage(r.Context()),
+		"workers": checkWorkers(),
+	}
+
+	status := checkOK
+	for _, c := range checks {
+		if c.Status == checkFailed {
+			status = checkFailed
+			break
+		}
+		if c.Status == checkDegraded {
+			status = checkDegraded
+		}
+	}
+
+	code := http.StatusOK
+	if status != checkOK {
+		code = http.StatusServiceUnavailable
+	}
+	respondJSON(w, code, DeepHealthResponse{Status: status, Service: "image-microservice", Checks: checks})
+}
+
+func checkDB(r *http.Request) CheckResult {
+	start := time.Now()
+	if err := db.PingContext(r.Context()); err != nil {
+		return CheckResult{Status: checkFailed, Error: err.Error()}
+	}
+	return CheckResult{Status: checkOK, LatencyMS: time.Since(start).Milliseconds()}
+}
+
+func checkSchema() CheckResult {
+	version, err := schemaVersion(db)
+	if err != nil {
+		return CheckResult{Status: checkFailed, Error: err.Error()}
+	}
+	details := map[string]int{"version": version, "expected": latestSchemaVersion()}
+	if version < latestSchemaVersion() {
+		return CheckResult{Status: checkFailed, Error: "esquema desactualizado", Details: details}
+	}
+	return CheckResult{Status: checkOK, Details: details}
+}
+
+// checkStorage prueba cada backend registrado. En los volúmenes locales
+// escribe, lee y borra un archivo chico con el sufijo de parciales, para
+// que cleanupLoop lo borre si el proceso muere a mitad de la prueba; en
+// los remotos basta con un Stat de una clave centinela (que no exista
+// también cuenta como respuesta).
+func checkStorage(ctx context.Context) CheckResult {
+	names := make([]string, 0, len(backends))
+	for name := range backends {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	result := CheckResult{Status: checkOK}
+	var details []VolumeCheck
+	for _, name := range names {
+		vc := VolumeCheck{Name: name, Status: checkOK}
+		start := time.Now()
+		local, isLocal := backends[name].(*localBackend)
+		var err error
+		if isLocal {
+			err = probeVolume(local.volume)
+		} else {
+			err = probeBackend(ctx, backends[name])
+		}
+		if err != nil {
+			vc.Status, vc.Error = checkFailed, err.Error()
+		}
+		vc.LatencyMS = time.Since(start).Milliseconds()
+
+		if isLocal {
+			if free, err := diskFree(local.volume.Path); err == nil {
+				vc.FreeBytes = free
+				if vc.Status == checkOK && minFreeBytes > 0 && free < uint64(minFreeBytes) {
+					vc.Status, vc.Error = checkDegraded, "poco espacio libre: "+formatSize(int64(free))
+				}
+			}
+		}
+
+		if vc.Status == checkFailed {
+			result.Status = checkFailed
+		} else if vc.Status == checkDegraded && result.Status == checkOK {
+			result.Status = checkDegraded
+		}
+		details = append(details, vc)
+	}
+	result.Details = details
+	return result
+}
+
+// probeTimeout acota la prueba de un backend remoto
+const probeTimeout = 5 * time.Second
+
+func probeBackend(ctx context.Context, b Backend) error {
+	ctx, cancel := context.WithTimeout(ctx, probeTimeout)
+	defer cancel()
+	if _, err := b.Stat(ctx, b.Key(".healthcheck", "sentinel")); err != nil && !errors.Is(err, errBlobNotFound) {
+		return fmt.Errorf("stat: %w", err)
+	}
+	return nil
+}
+
+func probeVolume(v Volume) error {
+	token := make([]byte, 16)
+	if _, err := rand.Read(token); err != nil {
+		return err
+	}
+	path := filepath.Join(v.Path, ".healthcheck-"+hex.EncodeToString(token)+partialSuffix)
+	defer os.Remove(path)
+
+	if err := os.WriteFile(path, token, 0644); err != nil {
+		return fmt.Errorf("escritura: %w", err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("lectura: %w", err)
+	}
+	if !bytes.Equal(data, token) {
+		return fmt.Errorf("lectura: contenido distinto al escrito")
+	}
+	if err := os.Remove(path); err != nil {
+		return fmt.Errorf("borrado: %w", err)
+	}
+	return nil
+}
+
+func checkWorkers() CheckResult {
+	workersMu.Lock()
+	defer workersMu.Unlock()
+
+	result := CheckResult{Status: checkOK}
+	details := make([]WorkerCheck, 0, len(workers))
+	now := time.Now()
+	for name, w := range workers {
+		wc := WorkerCheck{Name: name, Interval: w.interval.String(), Status: checkOK}
+		deadline := 2*w.interval + workerGrace
+
+		switch {
+		case !w.lastBeat.IsZero():
+			beat := w.lastBeat.UTC()
+			wc.LastBeat = &beat
+			if now.Sub(w.lastBeat) > deadline {
+				wc.Status = checkFailed
+			}
+		case now.Sub(w.registered) > deadline:
+			wc.Status = checkFailed
+		default:
+			wc.Status = "starting"
+		}
+		if wc.Status == checkFailed {
+			result.Status = checkFailed
+		}
+		details = append(details, wc)
+	}
+	sort.Slice(details, func(i, j int) bool { return details[i].Name < details[j].Name })
+	result.Details = details
+	return result
+}
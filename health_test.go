@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"testing"
+)
+
+// statBackend es un backend remoto de mentira que solo responde Stat
+type statBackend struct {
+	name string
+	err  error
+}
+
+func (b statBackend) Name() string                       { return b.name }
+func (b statBackend) Key(userID, filename string) string { return userID + "/" + filename }
+func (b statBackend) Put(ctx context.Context, key string, src io.Reader) (int64, string, error) {
+	return 0, "", errors.New("no implementado")
+}
+func (b statBackend) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	return nil, errBlobNotFound
+}
+func (b statBackend) Stat(ctx context.Context, key string) (BlobInfo, error) {
+	return BlobInfo{}, b.err
+}
+func (b statBackend) Delete(ctx context.Context, key string) error { return nil }
+
+func withBackends(t *testing.T, list ...Backend) {
+	saved := backends
+	backends = make(map[string]Backend)
+	for _, b := range list {
+		backends[b.Name()] = b
+	}
+	t.Cleanup(func() { backends = saved })
+}
+
+func TestCheckStorageProbesEveryBackend(t *testing.T) {
+	withBackends(t,
+		&localBackend{volume: Volume{Name: "default", Path: t.TempDir()}},
+		statBackend{name: "s3", err: errBlobNotFound},
+	)
+	result := checkStorage(context.Background())
+	if result.Status != checkOK {
+		t.Fatalf("status %s, se esperaba ok: %+v", result.Status, result.Details)
+	}
+	details := result.Details.([]VolumeCheck)
+	if len(details) != 2 || details[0].Name != "default" || details[1].Name != "s3" {
+		t.Fatalf("detalles inesperados: %+v", details)
+	}
+}
+
+func TestCheckStorageFailsOnRemoteError(t *testing.T) {
+	withBackends(t,
+		&localBackend{volume: Volume{Name: "default", Path: t.TempDir()}},
+		statBackend{name: "s3", err: errors.New("S3 respondió 403: AccessDenied")},
+	)
+	result := checkStorage(context.Background())
+	if result.Status != checkFailed {
+		t.Fatalf("status %s, se esperaba failed", result.Status)
+	}
+	if vc := result.Details.([]VolumeCheck)[1]; vc.Status != checkFailed || vc.Error == "" {
+		t.Fatalf("el backend s3 debería fallar: %+v", vc)
+	}
+}
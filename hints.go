@@ -0,0 +1,35 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+)
+
+// earlyHintsCount (EARLY_HINTS_COUNT) es cuántas imágenes del listado se
+// anuncian con preload; 0 lo deshabilita
+var earlyHintsCount int
+
+// earlyHintsWidth (EARLY_HINTS_WIDTH) es el ancho de la miniatura que se
+// anuncia: precargar el original gastaría el ancho de banda del listado
+var earlyHintsWidth int
+
+// sendEarlyHints agrega "Link: rel=preload" con la miniatura de las
+// primeras imágenes y los envía en un 103 Early Hints, así el navegador
+// empieza a descargarlas antes de recibir y parsear el JSON. Los headers
+// quedan también en la respuesta final para clientes que ignoran el 103.
+func sendEarlyHints(w http.ResponseWriter, r *http.Request, images []Image) {
+	if earlyHintsCount <= 0 || len(images) == 0 {
+		return
+	}
+
+	n := min(len(images), earlyHintsCount)
+	for _, img := range images[:n] {
+		thumb := transformURL(img.URL, transformParams{Width: earlyHintsWidth, Fit: "contain"})
+		w.Header().Add("Link", fmt.Sprintf("<%s>; rel=preload; as=image", thumb))
+	}
+
+	// Los 1xx no existen en HTTP/1.0
+	if r.ProtoAtLeast(1, 1) {
+		w.WriteHeader(http.StatusEarlyHints)
+	}
+}
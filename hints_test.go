@@ -0,0 +1,38 @@
+package main
+
+import (
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestSendEarlyHintsPreloadsSignedThumbnail(t *testing.T) {
+	savedCount, savedWidth, savedKey := earlyHintsCount, earlyHintsWidth, transformSigningKey
+	t.Cleanup(func() { earlyHintsCount, earlyHintsWidth, transformSigningKey = savedCount, savedWidth, savedKey })
+	earlyHintsCount, earlyHintsWidth, transformSigningKey = 1, 320, []byte("secreto")
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("GET", "/v1/images/u1", nil)
+	sendEarlyHints(w, r, []Image{{URL: "/image/u1/a"}, {URL: "/image/u1/b"}})
+
+	links := w.Header().Values("Link")
+	if len(links) != 1 {
+		t.Fatalf("se esperaba un Link, hubo %q", links)
+	}
+	target := strings.TrimSuffix(strings.TrimPrefix(links[0], "<"), ">; rel=preload; as=image")
+	u, err := url.Parse(target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u.Path != "/image/u1/a" {
+		t.Fatalf("preload de %s", u.Path)
+	}
+	p, ok, err := parseTransform(u.Query())
+	if err != nil || !ok || p.Width != 320 {
+		t.Fatalf("el preload no es una miniatura de 320px: %s (%v)", target, err)
+	}
+	if !verifyTransformSignature(u.Path, p, u.Query().Get("s")) {
+		t.Fatalf("firma inválida en %s", target)
+	}
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+const (
+	maxMetaPartBytes = 64 << 10 // JSON de metadata por archivo
+	maxMetadataBytes = 16 << 10 // objeto metadata libre
+	maxCaptionLen    = 2000
+	maxAlbumIDLen    = 100
+	maxTagsPerImage  = 20
+	maxTagLen        = 40
+)
+
+// imageMeta es la descripción opcional que acompaña a un archivo subido
+type imageMeta struct {
+	Caption  string          `json:"caption"`
+	Tags     []string        `json:"tags"`
+	Metadata json.RawMessage `json:"metadata"`
+	AlbumID  string          `json:"album_id"`
+
+	// skipDuplicate (solo -import) descarta la imagen si el usuario ya
+	// tiene el mismo contenido, comprobado bajo el lock del usuario
+	skipDuplicate bool
+}
+
+func errInvalidMetadata(err error) *uploadError {
+	return &uploadError{http.StatusBadRequest, "INVALID_METADATA", "metadata inválida: " + err.Error()}
+}
+
+// formImageMeta busca el JSON de metadata del archivo index del campo
+// images, en metadata[<index>] o metadata[<filename>]. parts tiene las
+// partes metadata[...] del formulario, vinieran como campo de texto o como
+// parte con archivo (p. ej. application/json).
+func formImageMeta(parts map[string][]byte, index int, filename string) (imageMeta, error) {
+	for _, key := range []string{"metadata[" + strconv.Itoa(index) + "]", "metadata[" + filename + "]"} {
+		if data, ok := parts[key]; ok {
+			return parseImageMeta(data)
+		}
+	}
+	return imageMeta{}, nil
+}
+
+func parseImageMeta(data []byte) (imageMeta, error) {
+	var meta imageMeta
+	if len(data) > maxMetaPartBytes {
+		return meta, fmt.Errorf("excede %s", formatSize(maxMetaPartBytes))
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&meta); err != nil {
+		return meta, fmt.Errorf("JSON inválido")
+	}
+	return meta, meta.validate()
+}
+
+func (m *imageMeta) validate() error {
+	m.Caption = strings.TrimSpace(m.Caption)
+	if len([]rune(m.Caption)) > maxCaptionLen {
+		return fmt.Errorf("caption excede %d caracteres", maxCaptionLen)
+	}
+	m.AlbumID = strings.TrimSpace(m.AlbumID)
+	if len(m.AlbumID) > maxAlbumIDLen {
+		return fmt.Errorf("album_id excede %d caracteres", maxAlbumIDLen)
+	}
+
+	if len(m.Metadata) > 0 && !bytes.Equal(m.Metadata, []byte("null")) {
+		if len(m.Metadata) > maxMetadataBytes {
+			return fmt.Errorf("metadata excede %s", formatSize(maxMetadataBytes))
+		}
+		var obj map[string]interface{}
+		if err := json.Unmarshal(m.Metadata, &obj); err != nil {
+			return fmt.Errorf("metadata debe ser un objeto JSON")
+		}
+	} else {
+		m.Metadata = nil
+	}
+
+	tags, err := normalizeTags(m.Tags)
+	m.Tags = tags
+	return err
+}
+
+// normalizeTags recorta, pasa a minúsculas y deduplica los tags. Las comas
+// no se admiten porque son el separador con que se leen de la BD.
+func normalizeTags(raw []string) ([]string, error) {
+	seen := make(map[string]bool)
+	tags := make([]string, 0, len(raw))
+	for _, t := range raw {
+		t = strings.ToLower(strings.TrimSpace(t))
+		if t == "" || seen[t] {
+			continue
+		}
+		if len([]rune(t)) > maxTagLen || strings.Contains(t, ",") {
+			return nil, fmt.Errorf("tag inválido %q (máximo %d caracteres, sin comas)", t, maxTagLen)
+		}
+		seen[t] = true
+		tags = append(tags, t)
+	}
+	if len(tags) > maxTagsPerImage {
+		return nil, fmt.Errorf("máximo %d tags por imagen", maxTagsPerImage)
+	}
+	return tags, nil
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestFormImageMeta(t *testing.T) {
+	parts := map[string][]byte{
+		"metadata[0]":        []byte(`{"caption": "  Playa  ", "tags": ["Verano", "verano", " mar "], "metadata": {"iso": 100}}`),
+		"metadata[foto.jpg]": []byte(`{"album_id": "viaje"}`),
+	}
+
+	meta, err := formImageMeta(parts, 0, "otra.jpg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if meta.Caption != "Playa" || strings.Join(meta.Tags, ",") != "verano,mar" || string(meta.Metadata) != `{"iso": 100}` {
+		t.Fatalf("metadata por índice inesperada: %+v", meta)
+	}
+
+	meta, err = formImageMeta(parts, 1, "foto.jpg")
+	if err != nil || meta.AlbumID != "viaje" {
+		t.Fatalf("metadata por nombre inesperada: %+v (%v)", meta, err)
+	}
+
+	if meta, err = formImageMeta(parts, 2, "nada.jpg"); err != nil || meta.Caption != "" || meta.Tags != nil {
+		t.Fatalf("sin parte se esperaba metadata vacía: %+v (%v)", meta, err)
+	}
+}
+
+func TestParseImageMetaRejects(t *testing.T) {
+	cases := map[string]string{
+		"JSON inválido":      `{"caption": `,
+		"campo desconocido":  `{"color": "rojo"}`,
+		"campo interno":      `{"skipDuplicate": true}`,
+		"metadata no objeto": `{"metadata": [1, 2]}`,
+		"caption larga":      `{"caption": "` + strings.Repeat("a", maxCaptionLen+1) + `"}`,
+	}
+	for name, data := range cases {
+		if _, err := parseImageMeta([]byte(data)); err == nil {
+			t.Errorf("%s: se esperaba error", name)
+		}
+	}
+}
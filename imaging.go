@@ -0,0 +1,167 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"io"
+	"net/http"
+	"os"
+
+	_ "golang.org/x/image/webp"
+)
+
+// imageDimensions lee solo la cabecera del archivo para obtener ancho y alto
+func imageDimensions(path string) (int, int, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer file.Close()
+	return readDimensions(file)
+}
+
+// storedImageDimensions es imageDimensions sobre el original en su backend
+func storedImageDimensions(ctx context.Context, img Image) (int, int, error) {
+	file, err := openImage(ctx, img)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer file.Close()
+	return readDimensions(file)
+}
+
+func readDimensions(r io.Reader) (int, int, error) {
+	cfg, _, err := image.DecodeConfig(r)
+	if err != nil {
+		return 0, 0, err
+	}
+	return cfg.Width, cfg.Height, nil
+}
+
+// maxPixels (MAX_PIXELS) acota ancho×alto de lo que se decodifica: un
+// archivo chico puede declarar dimensiones que ocupan gigas en memoria
+var maxPixels int64
+
+var errTooManyPixels = errors.New("la imagen excede el máximo de píxeles")
+
+// decodeImage decodifica el original completo en memoria, después de
+// comprobar en la cabecera que no pase de maxPixels
+func decodeImage(ctx context.Context, img Image) (image.Image, error) {
+	file, err := openImage(ctx, img)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	// La cabecera leída se vuelve a anteponer para no abrir el original dos
+	// veces (en S3 serían dos GET)
+	var header bytes.Buffer
+	cfg, _, err := image.DecodeConfig(io.TeeReader(file, &header))
+	if err != nil {
+		return nil, err
+	}
+	if maxPixels > 0 && int64(cfg.Width)*int64(cfg.Height) > maxPixels {
+		return nil, fmt.Errorf("%w: %dx%d", errTooManyPixels, cfg.Width, cfg.Height)
+	}
+
+	decoded, _, err := image.Decode(io.MultiReader(&header, file))
+	return decoded, err
+}
+
+// respondDecodeError responde a un fallo de decodeImage; suffix se agrega
+// al mensaje para identificar la imagen
+func respondDecodeError(w http.ResponseWriter, err error, suffix string) {
+	if errors.Is(err, errTooManyPixels) {
+		respondError(w, http.StatusUnprocessableEntity,
+			fmt.Sprintf("La imagen excede el máximo de %d píxeles%s", maxPixels, suffix))
+		return
+	}
+	respondError(w, http.StatusUnprocessableEntity, "No se pudo decodificar la imagen"+suffix)
+}
+
+// luminance aplica la ponderación Rec. 601 sobre valores de 8 bits
+func luminance(r, g, b uint32) float64 {
+	return 0.299*float64(r) + 0.587*float64(g) + 0.114*float64(b)
+}
+
+// storedDimensions usa las dimensiones guardadas en BD y solo lee el
+// archivo para imágenes anteriores a que se registraran
+func storedDimensions(ctx context.Context, img Image) (int, int, error) {
+	if img.Width != nil && img.Height != nil {
+		return *img.Width, *img.Height, nil
+	}
+	return storedImageDimensions(ctx, img)
+}
+
+// resizeImage escala a width x height promediando el área de origen que
+// cubre cada píxel de destino (box filter). Al ampliar equivale a vecino
+// más cercano.
+func resizeImage(src image.Image, width, height int) *image.NRGBA {
+	dst := image.NewNRGBA(image.Rect(0, 0, width, height))
+	b := src.Bounds()
+	sw, sh := b.Dx(), b.Dy()
+	if sw == 0 || sh == 0 || width == 0 || height == 0 {
+		return dst
+	}
+
+	for y := 0; y < height; y++ {
+		y0 := b.Min.Y + y*sh/height
+		y1 := b.Min.Y + (y+1)*sh/height
+		if y1 <= y0 {
+			y1 = y0 + 1
+		}
+		for x := 0; x < width; x++ {
+			x0 := b.Min.X + x*sw/width
+			x1 := b.Min.X + (x+1)*sw/width
+			if x1 <= x0 {
+				x1 = x0 + 1
+			}
+
+			var r, g, bl, a, n uint64
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					c := color.NRGBA64Model.Convert(src.At(sx, sy)).(color.NRGBA64)
+					r += uint64(c.R)
+					g += uint64(c.G)
+					bl += uint64(c.B)
+					a += uint64(c.A)
+					n++
+				}
+			}
+			dst.SetNRGBA(x, y, color.NRGBA{
+				R: uint8(r / n >> 8),
+				G: uint8(g / n >> 8),
+				B: uint8(bl / n >> 8),
+				A: uint8(a / n >> 8),
+			})
+		}
+	}
+	return dst
+}
+
+// fitWithin calcula el tamaño que entra en maxW x maxH sin deformar ni ampliar
+func fitWithin(width, height, maxW, maxH int) (int, int) {
+	if width <= maxW && height <= maxH {
+		return width, height
+	}
+	scale := float64(maxW) / float64(width)
+	if s := float64(maxH) / float64(height); s < scale {
+		scale = s
+	}
+	w := int(float64(width)*scale + 0.5)
+	h := int(float64(height)*scale + 0.5)
+	if w < 1 {
+		w = 1
+	}
+	if h < 1 {
+		h = 1
+	}
+	return w, h
+}
@@ -0,0 +1,249 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+const importProgressEvery = 1000
+
+type importOptions struct {
+	dir     string
+	userID  string // si está vacío, el primer subdirectorio es el usuario
+	mapFile string // CSV directorio,user_id
+	move    bool
+	dryRun  bool
+	workers int
+}
+
+type importJob struct {
+	path   string
+	userID string
+}
+
+type importStats struct {
+	imported   atomic.Int64
+	duplicates atomic.Int64
+	rejected   atomic.Int64
+	failed     atomic.Int64
+}
+
+// runImport recorre un árbol de imágenes existentes y las incorpora como
+// si se hubieran subido. Los archivos cuyo contenido el usuario ya tiene se
+// saltean, así una importación interrumpida se puede volver a correr.
+func runImport(opts importOptions) error {
+	userMap, err := loadImportMap(opts.mapFile)
+	if err != nil {
+		return err
+	}
+	if opts.workers < 1 {
+		opts.workers = 1
+	}
+
+	var stats importStats
+	jobs := make(chan importJob)
+	var wg sync.WaitGroup
+	for i := 0; i < opts.workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for job := range jobs {
+				importFile(job, opts, &stats)
+			}
+		}()
+	}
+
+	log.Printf("→ Importando desde %s (dry-run: %v, mover: %v)", opts.dir, opts.dryRun, opts.move)
+	var seen int64
+	walkErr := filepath.WalkDir(opts.dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			log.Printf("✗ %s: %v", path, err)
+			stats.failed.Add(1)
+			return nil
+		}
+		// Los directorios ocultos (como .renditions en un volumen) no
+		// tienen originales
+		if d.IsDir() {
+			if path != opts.dir && strings.HasPrefix(d.Name(), ".") {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if strings.HasPrefix(d.Name(), ".") {
+			return nil
+		}
+
+		userID, ok := importUserFor(opts, userMap, path)
+		if !ok {
+			log.Printf("✗ %s: no se pudo determinar el usuario", path)
+			stats.rejected.Add(1)
+			return nil
+		}
+		jobs <- importJob{path: path, userID: userID}
+
+		if seen++; seen%importProgressEvery == 0 {
+			log.Printf("… %d archivos procesados (%d importados)", seen, stats.imported.Load())
+		}
+		return nil
+	})
+	close(jobs)
+	wg.Wait()
+
+	log.Printf("✅ Importación terminada: %d importados, %d duplicados, %d rechazados, %d fallidos",
+		stats.imported.Load(), stats.duplicates.Load(), stats.rejected.Load(), stats.failed.Load())
+
+	if walkErr != nil {
+		return walkErr
+	}
+	if n := stats.failed.Load(); n > 0 {
+		return fmt.Errorf("%d archivos fallaron", n)
+	}
+	return nil
+}
+
+func importFile(job importJob, opts importOptions, stats *importStats) {
+	ctx := context.Background()
+	name := filepath.Base(job.path)
+
+	// Validar como en la subida
+	if !isValidImageType(name) {
+		log.Printf("✗ %s: formato no válido", job.path)
+		stats.rejected.Add(1)
+		return
+	}
+	info, err := os.Stat(job.path)
+	if err != nil {
+		log.Printf("✗ %s: %v", job.path, err)
+		stats.failed.Add(1)
+		return
+	}
+	if info.Size() > maxFileSize {
+		log.Printf("✗ %s: %v", job.path, errFileTooLarge())
+		stats.rejected.Add(1)
+		return
+	}
+	if _, _, err := imageDimensions(job.path); err != nil {
+		log.Printf("✗ %s: no es una imagen válida: %v", job.path, err)
+		stats.rejected.Add(1)
+		return
+	}
+
+	checksum, err := fileChecksum(job.path)
+	if err != nil {
+		log.Printf("✗ %s: %v", job.path, err)
+		stats.failed.Add(1)
+		return
+	}
+	// Consulta rápida para no copiar en vano; la que vale se repite al
+	// registrar, bajo el lock del usuario, por si otro worker importa el
+	// mismo contenido a la vez
+	var exists bool
+	err = db.QueryRowContext(ctx, `SELECT EXISTS(SELECT 1 FROM images WHERE user_id = ? AND checksum = ? AND deleted_at IS NULL)`,
+		job.userID, checksum).Scan(&exists)
+	if err != nil {
+		log.Printf("✗ %s: Error BD: %v", job.path, err)
+		stats.failed.Add(1)
+		return
+	}
+	if exists {
+		stats.duplicates.Add(1)
+		if opts.move && !opts.dryRun {
+			removeImported(job.path)
+		}
+		return
+	}
+
+	if opts.dryRun {
+		stats.imported.Add(1)
+		return
+	}
+
+	file, err := os.Open(job.path)
+	if err != nil {
+		log.Printf("✗ %s: %v", job.path, err)
+		stats.failed.Add(1)
+		return
+	}
+	_, err = saveImage(ctx, job.userID, name, file, imageMeta{skipDuplicate: true})
+	file.Close()
+	if errors.Is(err, errDuplicate) {
+		stats.duplicates.Add(1)
+		if opts.move {
+			removeImported(job.path)
+		}
+		return
+	}
+	if err != nil {
+		log.Printf("✗ %s: %v", job.path, err)
+		stats.failed.Add(1)
+		return
+	}
+
+	stats.imported.Add(1)
+	if opts.move {
+		removeImported(job.path)
+	}
+}
+
+func removeImported(path string) {
+	if err := os.Remove(path); err != nil {
+		log.Printf("No se pudo eliminar el original %s: %v", path, err)
+	}
+}
+
+// importUserFor resuelve el usuario del archivo: -import-user si se dio, o
+// el primer subdirectorio bajo la raíz, traducido con el mapa si aparece
+func importUserFor(opts importOptions, userMap map[string]string, path string) (string, bool) {
+	if opts.userID != "" {
+		return opts.userID, true
+	}
+	rel, err := filepath.Rel(opts.dir, path)
+	if err != nil {
+		return "", false
+	}
+	parts := strings.Split(filepath.ToSlash(rel), "/")
+	if len(parts) < 2 {
+		return "", false // archivo suelto en la raíz
+	}
+	if mapped, ok := userMap[parts[0]]; ok {
+		return mapped, true
+	}
+	if len(userMap) > 0 {
+		return "", false // con mapa, solo se importan los directorios mapeados
+	}
+	return parts[0], true
+}
+
+func loadImportMap(path string) (map[string]string, error) {
+	userMap := make(map[string]string)
+	if path == "" {
+		return userMap, nil
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	records, err := csv.NewReader(file).ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("leyendo %s: %w", path, err)
+	}
+	for i, rec := range records {
+		if len(rec) != 2 || rec[0] == "" || rec[1] == "" {
+			return nil, fmt.Errorf("%s línea %d: se espera directorio,user_id", path, i+1)
+		}
+		userMap[strings.TrimSpace(rec[0])] = strings.TrimSpace(rec[1])
+	}
+	return userMap, nil
+}
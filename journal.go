@@ -0,0 +1,215 @@
+package main
+
+import (
+	"context"
+	"log"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Una subida puede durar mucho más que TRANSFER_TIMEOUT mientras avance
+// (el limitador de ancho de banda posterga el deadline), así que la edad
+// de la intención no dice nada. Cada réplica renueva touched_at de sus
+// subidas en curso cada journalHeartbeat; una intención sin renovar
+// durante journalGrace quedó abandonada.
+const (
+	journalHeartbeat = time.Minute
+	journalGrace     = 15 * time.Minute
+)
+
+// activeUploads son las intenciones abiertas por este proceso
+var activeUploads = struct {
+	sync.Mutex
+	ids map[string]bool
+}{ids: make(map[string]bool)}
+
+func journalBegin(ctx context.Context, imageID, userID, volume, path string) error {
+	_, err := db.ExecContext(ctx, `INSERT INTO pending_uploads (image_id, user_id, volume, file_path) VALUES (?, ?, ?, ?)`,
+		imageID, userID, volume, path)
+	if err == nil {
+		activeUploads.Lock()
+		activeUploads.ids[imageID] = true
+		activeUploads.Unlock()
+	}
+	return err
+}
+
+func journalRelease(imageID string) {
+	activeUploads.Lock()
+	delete(activeUploads.ids, imageID)
+	activeUploads.Unlock()
+}
+
+func isActiveUpload(imageID string) bool {
+	activeUploads.Lock()
+	defer activeUploads.Unlock()
+	return activeUploads.ids[imageID]
+}
+
+// journalHeartbeatLoop mantiene vivas las intenciones de este proceso
+func journalHeartbeatLoop() {
+	ticker := time.NewTicker(journalHeartbeat)
+	defer ticker.Stop()
+	for range ticker.C {
+		activeUploads.Lock()
+		args := make([]interface{}, 0, len(activeUploads.ids))
+		for id := range activeUploads.ids {
+			args = append(args, id)
+		}
+		activeUploads.Unlock()
+		if len(args) == 0 {
+			continue
+		}
+		query := `UPDATE pending_uploads SET touched_at = NOW() WHERE image_id IN (?` + strings.Repeat(", ?", len(args)-1) + `)`
+		if _, err := db.Exec(query, args...); err != nil {
+			log.Printf("Error BD renovando subidas pendientes: %v", err)
+		}
+	}
+}
+
+// journalCommit inserta la imagen y elimina su intención atómicamente.
+// Cantidad y cuota se vuelven a comprobar bajo el lock del usuario: las
+// validaciones del handler corren antes y dos subidas simultáneas pueden
+// pasarlas a la vez, o elegir el mismo nombre visible. Con skipDuplicate
+// también se descarta el contenido que el usuario ya tiene.
+func journalCommit(ctx context.Context, img *Image, skipDuplicate bool) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// El contador del usuario va primero, como en los tags masivos, para
+	// que ambas transacciones tomen los locks en el mismo orden
+	if _, err := lockUserCounters(ctx, tx, img.UserID); err != nil {
+		return err
+	}
+	if skipDuplicate {
+		var exists bool
+		err := tx.QueryRowContext(ctx, `SELECT EXISTS(SELECT 1 FROM images WHERE user_id = ? AND checksum = ? AND deleted_at IS NULL)`,
+			img.UserID, img.Checksum).Scan(&exists)
+		if err != nil {
+			return err
+		}
+		if exists {
+			return errDuplicate
+		}
+	}
+	if maxUserImages > 0 || userQuotaBytes > 0 {
+		var usage, count int64
+		err := tx.QueryRowContext(ctx, `SELECT COALESCE(SUM(size_bytes), 0), COUNT(*) FROM images
+			WHERE user_id = ? AND deleted_at IS NULL`, img.UserID).Scan(&usage, &count)
+		if err != nil {
+			return err
+		}
+		if reachedImageLimit(count) {
+			return errLimitReached
+		}
+		if exceedsQuota(usage, img.SizeBytes) {
+			return errQuotaExceeded
+		}
+	}
+
+	displayName, err := uniqueDisplayName(ctx, tx, img.UserID, img.Filename)
+	if err != nil {
+		return err
+	}
+	img.DisplayName = displayName
+
+	query := `INSERT INTO images (id, user_id, filename, display_name, file_path, mime_type, size_bytes, width, height,
+			  volume, checksum, caption, album_id, metadata)
+			  VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, NULLIF(?, ''), NULLIF(?, ''), ?)`
+	var metadata interface{}
+	if len(img.Metadata) > 0 {
+		metadata = string(img.Metadata)
+	}
+	if _, err := tx.ExecContext(ctx, query, img.ID, img.UserID, img.Filename, img.DisplayName, img.FilePath, img.MimeType,
+		img.SizeBytes, img.Width, img.Height, img.Volume, img.Checksum, img.Caption, img.AlbumID, metadata); err != nil {
+		return err
+	}
+	for _, tag := range img.Tags {
+		if _, err := tx.ExecContext(ctx, `INSERT INTO image_tags (image_id, user_id, tag) VALUES (?, ?, ?)`,
+			img.ID, img.UserID, tag); err != nil {
+			return err
+		}
+	}
+	if err := recordChange(ctx, tx, img.UserID, img.ID, changeCreated); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM pending_uploads WHERE image_id = ?`, img.ID); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	journalRelease(img.ID)
+	return nil
+}
+
+// journalAbort limpia el archivo y la intención de una subida fallida. No
+// usa el contexto de la petición para que la limpieza corra aunque se haya
+// cancelado; si falla, recoverPendingUploads lo resuelve más tarde.
+func journalAbort(imageID, volume, path string) {
+	defer journalRelease(imageID)
+	backend, err := backendFor(Image{Volume: volume, FilePath: path})
+	if err == nil {
+		err = backend.Delete(context.Background(), path)
+	}
+	if err != nil {
+		log.Printf("Error eliminando %s: %v", path, err)
+		return
+	}
+	if _, err := db.Exec(`DELETE FROM pending_uploads WHERE image_id = ?`, imageID); err != nil {
+		log.Printf("Error BD: %v", err)
+	}
+}
+
+// recoverPendingUploads resuelve intenciones abandonadas: si la imagen llegó
+// a la BD solo se borra la intención, si no se borra el archivo huérfano
+func recoverPendingUploads() {
+	rows, err := db.Query(`SELECT image_id, COALESCE(volume, ''), file_path FROM pending_uploads WHERE touched_at < ?`,
+		time.Now().Add(-journalGrace))
+	if err != nil {
+		log.Printf("Error BD recuperando subidas pendientes: %v", err)
+		return
+	}
+
+	type pending struct{ id, volume, path string }
+	var items []pending
+	for rows.Next() {
+		var p pending
+		if err := rows.Scan(&p.id, &p.volume, &p.path); err != nil {
+			log.Printf("Error escaneando fila: %v", err)
+			continue
+		}
+		if isActiveUpload(p.id) {
+			continue
+		}
+		items = append(items, p)
+	}
+	rows.Close()
+
+	var committed, removed int
+	for _, p := range items {
+		var exists bool
+		if err := db.QueryRow(`SELECT EXISTS(SELECT 1 FROM images WHERE id = ?)`, p.id).Scan(&exists); err != nil {
+			log.Printf("Error BD: %v", err)
+			continue
+		}
+		if exists {
+			if _, err := db.Exec(`DELETE FROM pending_uploads WHERE image_id = ?`, p.id); err != nil {
+				log.Printf("Error BD: %v", err)
+				continue
+			}
+			committed++
+			continue
+		}
+		journalAbort(p.id, p.volume, p.path)
+		removed++
+	}
+
+	if len(items) > 0 {
+		log.Printf("✅ Subidas pendientes recuperadas: %d ya registradas, %d archivos huérfanos eliminados", committed, removed)
+	}
+}
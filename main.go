@@ -1,42 +1,93 @@
 package main
 
 import (
+	"context"
 	"crypto/sha256"
 	"database/sql"
 	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
 	"io"
 	"log"
 	"net/http"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
 	_ "github.com/go-sql-driver/mysql"
-	"github.com/google/uuid"
 )
 
 const (
-	uploadDir   = "./uploads"
-	maxMemory   = 32 << 20 // 32 MB
-	maxFileSize = 10 << 20 // 10 MB por imagen
+	maxMemory = 32 << 20 // 32 MB, metadata de un formulario de subida
 )
 
-var db *sql.DB
+var (
+	db            *sql.DB
+	publicBaseURL string // PUBLIC_BASE_URL, base para URLs absolutas
+	maxFileSize   int64  // MAX_FILE_SIZE, por imagen
+	maxUploadSize int64  // MAX_UPLOAD_SIZE, body completo de /upload
+)
 
 type Image struct {
-	ID        string     `json:"id"`
-	UserID    string     `json:"user_id"`
-	Filename  string     `json:"filename"`
-	FilePath  string     `json:"file_path"`
-	MimeType  string     `json:"mime_type"`
-	SizeBytes int64      `json:"size_bytes"`
-	CreatedAt time.Time  `json:"created_at"`
-	DeletedAt *time.Time `json:"deleted_at,omitempty"`
-	URL       string     `json:"url"`
+	ID            string          `json:"id"`
+	UserID        string          `json:"user_id"`
+	Filename      string          `json:"filename"`
+	DisplayName   string          `json:"display_name,omitempty"` // Filename desambiguado dentro de la biblioteca del usuario
+	FilePath      string          `json:"file_path"`
+	MimeType      string          `json:"mime_type"`
+	SizeBytes     int64           `json:"size_bytes"`
+	CreatedAt     time.Time       `json:"created_at"`
+	DeletedAt     *time.Time      `json:"deleted_at,omitempty"`
+	DeletedBy     string          `json:"deleted_by,omitempty"`
+	DeleteReason  string          `json:"delete_reason,omitempty"`
+	DeleteChannel string          `json:"delete_channel,omitempty"`
+	Width         *int            `json:"width,omitempty"`
+	Height        *int            `json:"height,omitempty"`
+	Volume        string          `json:"volume,omitempty"`
+	Checksum      string          `json:"checksum,omitempty"` // sha256 en hex
+	Caption       string          `json:"caption,omitempty"`
+	AlbumID       string          `json:"album_id,omitempty"`
+	Tags          []string        `json:"tags,omitempty"`
+	Metadata      json.RawMessage `json:"metadata,omitempty"` // objeto JSON libre del cliente
+	URL           string          `json:"url"`
+}
+
+// imageColumns es el orden de columnas que espera scanImage
+const imageColumns = `id, user_id, filename, COALESCE(display_name, ''), file_path, mime_type, size_bytes, created_at, deleted_at, width, height,
+	COALESCE(volume, ''), COALESCE(checksum, ''), COALESCE(caption, ''), COALESCE(album_id, ''), metadata,
+	(SELECT GROUP_CONCAT(tag ORDER BY tag SEPARATOR ',') FROM image_tags WHERE image_tags.image_id = images.id),
+	COALESCE(deleted_by, ''), COALESCE(delete_reason, ''), COALESCE(delete_channel, '')`
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanImage(row rowScanner) (Image, error) {
+	var img Image
+	var metadata []byte
+	var tags sql.NullString
+	err := row.Scan(&img.ID, &img.UserID, &img.Filename, &img.DisplayName, &img.FilePath,
+		&img.MimeType, &img.SizeBytes, &img.CreatedAt, &img.DeletedAt,
+		&img.Width, &img.Height, &img.Volume, &img.Checksum,
+		&img.Caption, &img.AlbumID, &metadata, &tags,
+		&img.DeletedBy, &img.DeleteReason, &img.DeleteChannel)
+	// Filas anteriores a la normalización se devuelven ya normalizadas
+	img.Filename = sanitizeFilename(img.Filename)
+	if img.DisplayName == "" {
+		img.DisplayName = img.Filename
+	}
+	if len(metadata) > 0 {
+		img.Metadata = json.RawMessage(metadata)
+	}
+	if tags.String != "" {
+		img.Tags = strings.Split(tags.String, ",")
+	}
+	return img, err
 }
 
 type ImageResponse struct {
@@ -49,8 +100,19 @@ type ImageResponse struct {
 
 type UploadResponse struct {
 	Success bool            `json:"success"`
+	Status  int             `json:"status"`
 	Images  []ImageResponse `json:"images"`
 	Errors  []string        `json:"errors,omitempty"`
+	Results []UploadResult  `json:"results"`
+}
+
+// UploadResult es el resultado de un archivo, en el orden en que llegaron
+type UploadResult struct {
+	Filename string         `json:"filename"`
+	Status   int            `json:"status"`
+	Code     string         `json:"code,omitempty"`
+	Error    string         `json:"error,omitempty"`
+	Image    *ImageResponse `json:"image,omitempty"`
 }
 
 type ListResponse struct {
@@ -60,6 +122,17 @@ type ListResponse struct {
 }
 
 func main() {
+	migrateOnly := flag.Bool("migrate", false, "aplicar migraciones y salir")
+	importDir := flag.String("import", "", "importar imágenes existentes desde este directorio y salir")
+	importUser := flag.String("import-user", "", "asignar todas las imágenes importadas a este user_id")
+	importMap := flag.String("import-map", "", "CSV directorio,user_id para mapear subdirectorios a usuarios")
+	importMove := flag.Bool("import-move", false, "eliminar los originales una vez importados")
+	importDryRun := flag.Bool("import-dry-run", false, "validar sin copiar ni crear registros")
+	importWorkers := flag.Int("import-workers", 4, "archivos importados en paralelo")
+	checkOnly := flag.Bool("check", false, "verificar consistencia entre BD y almacenamiento, imprimir reporte JSON y salir")
+	checkChecksums := flag.Bool("check-checksums", true, "en -check, recalcular el SHA-256 de cada archivo")
+	flag.Parse()
+
 	// Conectar a MySQL
 	var err error
 	dsn := os.Getenv("MYSQL_DSN_IMAGE")
@@ -80,82 +153,363 @@ func main() {
 	}
 	log.Println("✅ Conectado a MySQL")
 
-	// Crear tabla si no existe
-	if err := createTable(); err != nil {
-		log.Fatal("Error creando tabla:", err)
+	// Aplicar migraciones pendientes, o solo verificarlas si el DDL se
+	// ejecuta fuera del servicio (DB_AUTO_MIGRATE=false)
+	autoMigrate := *migrateOnly || getEnv("DB_AUTO_MIGRATE", "true") != "false"
+	if autoMigrate {
+		if err := runMigrations(); err != nil {
+			log.Fatal("Error aplicando migraciones:", err)
+		}
+		if *migrateOnly {
+			return
+		}
 	}
 
-	// Crear directorio de uploads si no existe
-	if err := os.MkdirAll(uploadDir, 0755); err != nil {
-		log.Fatal("Error creando directorio uploads:", err)
+	// Crear directorios de uploads si no existen
+	volumes, err = parseVolumes(os.Getenv("UPLOAD_VOLUMES"), getEnv("UPLOAD_DIR", "./uploads"))
+	if err != nil {
+		log.Fatal("UPLOAD_VOLUMES inválido:", err)
+	}
+	for _, v := range volumes {
+		if err := os.MkdirAll(v.Path, 0755); err != nil {
+			log.Fatalf("Error creando directorio de uploads %s: %v", v.Path, err)
+		}
+		log.Printf("✅ Volumen %s en %s (peso %d)", v.Name, v.Path, v.Weight)
+	}
+	if err := registerBackends(); err != nil {
+		log.Fatal("Configuración de backends inválida:", err)
 	}
 
+	loadConfig()
+
+	if (*importDir != "" || *checkOnly) && !autoMigrate {
+		if err := verifySchema(); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	// Modo verificación: sale con código 1 si hay inconsistencias y 2 si
+	// no se pudo completar
+	if *checkOnly {
+		report, err := runCheck(*checkChecksums)
+		if err != nil {
+			log.Println("Error verificando consistencia:", err)
+			os.Exit(2)
+		}
+		printCheckReport(report)
+		if !report.OK {
+			log.Printf("⚠️  %d inconsistencias encontradas", len(report.Issues))
+			os.Exit(1)
+		}
+		log.Printf("✅ Sin inconsistencias (%d imágenes, %d archivos)", report.Images, report.Files)
+		return
+	}
+
+	// Modo importación: corre y sale sin levantar el servidor
+	if *importDir != "" {
+		err := runImport(importOptions{
+			dir:     *importDir,
+			userID:  *importUser,
+			mapFile: *importMap,
+			move:    *importMove,
+			dryRun:  *importDryRun,
+			workers: *importWorkers,
+		})
+		if err != nil {
+			log.Fatal("Importación con errores:", err)
+		}
+		return
+	}
+
+	registerWorker("maintenance", journalGrace)
+	registerWorker("usage_flush", usageFlushInterval)
+	registerWorker("cleanup", cleanupInterval)
+	if autoMigrate {
+		go startMaintenance()
+	} else {
+		setSchemaStatus(fmt.Errorf("verificando esquema"))
+		go waitForSchema(startMaintenance)
+	}
+	go flushUsageLoop()
+	go cleanupLoop()
+	go journalHeartbeatLoop()
+
 	r := chi.NewRouter()
 
 	// Middleware
+	r.Use(requestID)
 	r.Use(middleware.Logger)
 	r.Use(middleware.Recoverer)
-	r.Use(middleware.RealIP)
+	r.Use(realIP)
 
-	// Routes
-	r.Post("/upload", uploadHandler)
-	r.Get("/image/{userId}/{id}", downloadHandler)
-	r.Get("/images/{userId}", listImagesHandler)
-	r.Delete("/image/{userId}/{id}", deleteImageHandler)
-	r.Get("/health", healthHandler)
+	r.Group(registerRoutes)
+
+	// Mismas rutas con respuestas envueltas en Envelope
+	r.Route("/v1", func(r chi.Router) {
+		r.Use(withEnvelope)
+		r.NotFound(func(w http.ResponseWriter, r *http.Request) {
+			respondError(w, http.StatusNotFound, "Ruta no encontrada")
+		})
+		r.MethodNotAllowed(func(w http.ResponseWriter, r *http.Request) {
+			respondError(w, http.StatusMethodNotAllowed, "Método no permitido")
+		})
+		registerRoutes(r)
+	})
 
 	port := ":8080"
 	log.Printf("🚀 Servidor iniciado en http://localhost%s", port)
 	log.Fatal(http.ListenAndServe(port, r))
 }
 
-func createTable() error {
-	query := `
-	CREATE TABLE IF NOT EXISTS images (
-		id VARCHAR(36) PRIMARY KEY,
-		user_id VARCHAR(100) NOT NULL,
-		filename VARCHAR(255) NOT NULL,
-		file_path VARCHAR(500) NOT NULL,
-		mime_type VARCHAR(50) NOT NULL,
-		size_bytes BIGINT NOT NULL,
-		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
-		deleted_at TIMESTAMP NULL,
-		INDEX idx_user_id (user_id),
-		INDEX idx_created_at (created_at),
-		INDEX idx_deleted_at (deleted_at)
-	) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
-	`
-	_, err := db.Exec(query)
-	if err != nil {
-		return err
+// registerRoutes define las rutas: timeout corto para la API JSON, largo
+// para transferencias y procesamiento de imágenes
+func registerRoutes(r chi.Router) {
+	r.Group(func(r chi.Router) {
+		r.Use(requestTimeout(jsonTimeout))
+		r.Get("/image/{userId}/{id}/info", imageInfoHandler)
+		r.Get("/images/{userId}", listImagesHandler)
+		r.Post("/images/{userId}/metadata", batchMetadataHandler)
+		r.Get("/images/{userId}/by-hash/{sha256}", hashLookupHandler)
+		r.Delete("/image/{userId}/{id}", deleteImageHandler)
+		r.Get("/images/{userId}/trash", trashHandler)
+		r.Get("/changes/{userId}", changesHandler)
+		r.Post("/images/{userId}/tags", bulkTagsHandler)
+		r.Get("/users/{userId}/slots", listSlotsHandler)
+		r.Put("/users/{userId}/slots/{slot}", assignSlotHandler)
+		r.Delete("/users/{userId}/slots/{slot}", clearSlotHandler)
+		r.Get("/users/{userId}/webhooks", listSubscriptionsHandler)
+		r.Post("/users/{userId}/webhooks", createSubscriptionHandler)
+		r.Get("/users/{userId}/webhooks/{id}", getSubscriptionHandler)
+		r.Patch("/users/{userId}/webhooks/{id}", updateSubscriptionHandler)
+		r.Delete("/users/{userId}/webhooks/{id}", deleteSubscriptionHandler)
+		r.Post("/users/{userId}/webhooks/{id}/test", testSubscriptionHandler)
+		r.Get("/users/{userId}/webhooks/{id}/deliveries", listDeliveriesHandler)
+		r.Get("/health", healthHandler)
+		r.Get("/ready", readyHandler)
+		r.Get("/metrics", metricsHandler)
+
+		// Enlaces compartidos
+		r.Post("/image/{userId}/{id}/share", createShareHandler)
+		r.Get("/s/{token}/qr.png", shareQRHandler)
+		r.Get("/p/{token}", previewHandler)
+		r.Get("/oembed", oembedHandler)
+	})
+
+	r.Group(func(r chi.Router) {
+		r.Use(requestTimeout(transferTimeout))
+		r.Get("/images/{userId}/export.zip", exportZipHandler)
+		r.Get("/users/{userId}/slots/{slot}", slotImageHandler)
+		r.With(throttleUploads).Post("/upload", uploadHandler)
+		r.Get("/image/{userId}/{id}", downloadHandler)
+		r.Get("/image/{userId}/{id}/analysis", analysisHandler)
+		r.Post("/compare", compareHandler)
+		r.Get("/s/{token}", shareHandler)
+
+		// Administración
+		r.Route("/admin", func(r chi.Router) {
+			r.Use(requireAdmin)
+			r.Get("/reports/usage", usageReportHandler)
+			r.Get("/audit", auditLogHandler)
+			r.Get("/throttle", getThrottleHandler)
+			r.Put("/throttle", updateThrottleHandler)
+			r.Post("/sign", signTransformHandler)
+			r.Post("/images/regenerate", regenerateImagesHandler)
+			r.Post("/images/{id}/regenerate", regenerateImageHandler)
+			r.Get("/storage/migrations", listStorageMigrationsHandler)
+			r.Post("/storage/migrations", createStorageMigrationHandler)
+			r.Get("/storage/migrations/{id}", getStorageMigrationHandler)
+			r.Post("/storage/migrations/{id}/resume", resumeStorageMigrationHandler)
+		})
+	})
+}
+
+// loadConfig lee la configuración por variables de entorno
+func loadConfig() {
+	setBandwidthLimits(BandwidthLimits{
+		UploadPerConn:     getEnvInt64("UPLOAD_RATE_PER_CONN", 0),
+		UploadGlobal:      getEnvInt64("UPLOAD_RATE_GLOBAL", 0),
+		DownloadPerClient: getEnvInt64("DOWNLOAD_RATE_PER_CLIENT", 0),
+		DownloadGlobal:    getEnvInt64("DOWNLOAD_RATE_GLOBAL", 0),
+	})
+	priorityMaxBytes = getEnvInt64("DOWNLOAD_PRIORITY_MAX_BYTES", 256<<10)
+
+	staleFileAge = getEnvDuration("STALE_FILE_AGE", time.Hour)
+	cleanupInterval = getEnvDuration("CLEANUP_INTERVAL", 30*time.Minute)
+
+	publicBaseURL = os.Getenv("PUBLIC_BASE_URL")
+	jsonTimeout = getEnvDuration("JSON_TIMEOUT", 10*time.Second)
+	transferTimeout = getEnvDuration("TRANSFER_TIMEOUT", 5*time.Minute)
+	earlyHintsCount = int(getEnvInt64("EARLY_HINTS_COUNT", 6))
+	earlyHintsWidth = int(getEnvInt64("EARLY_HINTS_WIDTH", 320))
+	if earlyHintsWidth <= 0 || earlyHintsWidth > maxTransformSize {
+		log.Fatalf("EARLY_HINTS_WIDTH debe estar entre 1 y %d", maxTransformSize)
+	}
+	transformSigningKey = []byte(os.Getenv("TRANSFORM_SIGNING_KEY"))
+	maxFileSize = getEnvInt64("MAX_FILE_SIZE", 10<<20)      // 10 MB por imagen
+	maxUploadSize = getEnvInt64("MAX_UPLOAD_SIZE", 100<<20) // 100 MB por petición
+	maxPixels = getEnvInt64("MAX_PIXELS", 50_000_000)       // ~8K×6K; 0 desactiva el límite
+	adminToken = os.Getenv("ADMIN_TOKEN")
+	webhookURLs = parseWebhookURLs(os.Getenv("WEBHOOK_URLS"))
+	webhookSecret = os.Getenv("WEBHOOK_SECRET")
+	userQuotaBytes = getEnvInt64("USER_QUOTA_BYTES", 0)
+	maxUserImages = getEnvInt64("MAX_IMAGES_PER_USER", 0)
+	minFreeBytes = getEnvInt64("HEALTH_MIN_FREE_BYTES", 1<<30)
+
+	var err error
+	if trustedProxies, err = parseTrustedProxies(os.Getenv("TRUSTED_PROXIES")); err != nil {
+		log.Fatal("TRUSTED_PROXIES inválido:", err)
+	}
+	if quotaThresholds, err = parseThresholds(getEnv("QUOTA_ALERT_THRESHOLDS", "80,95,100")); err != nil {
+		log.Fatal("QUOTA_ALERT_THRESHOLDS inválido:", err)
+	}
+}
+
+// startMaintenance corre las tareas que requieren el esquema al día: al
+// arrancar y luego periódicamente para intenciones que aún no vencían
+func startMaintenance() {
+	workerBeat("maintenance")
+	recoverPendingUploads()
+	backfillDimensions()
+	backfillChecksums()
+	resumeStorageMigrations()
+
+	ticker := time.NewTicker(journalGrace)
+	defer ticker.Stop()
+	for range ticker.C {
+		workerBeat("maintenance")
+		recoverPendingUploads()
 	}
-	log.Println("✅ Tabla 'images' verificada/creada")
-	return nil
 }
 
 func uploadHandler(w http.ResponseWriter, r *http.Request) {
-	// Parse multipart form
-	if err := r.ParseMultipartForm(maxMemory); err != nil {
+	// Recorrer las partes a medida que llegan, con el body acotado: cada
+	// imagen se escribe directo al volumen en vez de pasar por memoria o por
+	// un temporal de multipart
+	r.Body = http.MaxBytesReader(w, r.Body, maxUploadSize)
+	reader, err := r.MultipartReader()
+	if err != nil {
 		respondError(w, http.StatusBadRequest, "Error parseando formulario")
 		return
 	}
 
-	// Obtener user_id del formulario
-	userID := r.FormValue("user_id")
-	if userID == "" {
-		respondError(w, http.StatusBadRequest, "user_id es requerido")
-		return
+	// user_id puede venir en la query o como campo, antes de las imágenes
+	userID := r.URL.Query().Get("user_id")
+	metaParts := make(map[string][]byte)
+	var metaBytes int64
+
+	var usageBefore, usage, count, stored int64
+	var uploads []pendingUpload
+	started := false
+	abort := func() {
+		for _, u := range uploads {
+			if u.err == nil {
+				discardImage(u.img)
+			}
+		}
 	}
 
-	// Crear directorio del usuario si no existe
-	userDir := filepath.Join(uploadDir, userID)
-	if err := os.MkdirAll(userDir, 0755); err != nil {
-		respondError(w, http.StatusInternalServerError, "Error creando directorio de usuario")
-		return
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			abort()
+			respondBodyError(w, err)
+			return
+		}
+		name := part.FormName()
+
+		switch {
+		case name == "user_id" && part.FileName() == "":
+			if started {
+				abort()
+				respondError(w, http.StatusBadRequest, "user_id debe enviarse antes de las imágenes")
+				return
+			}
+			value, err := io.ReadAll(io.LimitReader(part, 256))
+			if err != nil {
+				respondBodyError(w, err)
+				return
+			}
+			userID = string(value)
+
+		case strings.HasPrefix(name, "metadata["):
+			data, err := io.ReadAll(io.LimitReader(part, maxMetaPartBytes+1))
+			if err != nil {
+				abort()
+				respondBodyError(w, err)
+				return
+			}
+			metaBytes += int64(len(data))
+			if metaBytes > maxMemory {
+				abort()
+				respondError(w, http.StatusRequestEntityTooLarge,
+					fmt.Sprintf("La metadata del formulario excede %s", formatSize(maxMemory)))
+				return
+			}
+			if _, ok := metaParts[name]; !ok {
+				metaParts[name] = data
+			}
+
+		case name == "images" && part.FileName() != "":
+			filename := part.FileName()
+			if !started {
+				if userID == "" {
+					respondError(w, http.StatusBadRequest, "user_id es requerido")
+					return
+				}
+				// Uso actual para validar la cuota y detectar umbrales cruzados
+				usageBefore, count, err = userUsage(r.Context(), userID)
+				if err != nil {
+					log.Printf("Error BD: %v", err)
+					respondError(w, http.StatusInternalServerError, "Error consultando cuota")
+					return
+				}
+				usage = usageBefore
+				started = true
+			}
+			upload := pendingUpload{filename: filename}
+
+			// Validar tipo de archivo, cantidad y cuota; el tamaño se controla
+			// mientras se escribe
+			body := &bodyReader{r: part}
+			var src io.Reader = body
+			if userQuotaBytes > 0 {
+				src = &fileLimitReader{r: body, remaining: userQuotaBytes - usage, err: errQuotaExceeded}
+			}
+			switch {
+			case !isValidImageType(filename):
+				upload.err = errInvalidFormat
+			case reachedImageLimit(count + stored):
+				upload.err = errLimitReached
+			case exceedsQuota(usage, 0):
+				upload.err = errQuotaExceeded
+			default:
+				img, err := storeImage(r.Context(), userID, filename, src)
+				if body.err != nil {
+					abort()
+					respondBodyError(w, body.err)
+					return
+				}
+				if err != nil {
+					upload.err = asUploadError(err)
+				} else {
+					upload.img = img
+					usage += img.SizeBytes
+					stored++
+				}
+			}
+			uploads = append(uploads, upload)
+		}
+		part.Close()
 	}
 
-	files := r.MultipartForm.File["images"]
-	if len(files) == 0 {
+	if userID == "" {
+		respondError(w, http.StatusBadRequest, "user_id es requerido")
+		return
+	}
+	if len(uploads) == 0 {
 		respondError(w, http.StatusBadRequest, "No se recibieron imágenes")
 		return
 	}
@@ -164,90 +518,107 @@ func uploadHandler(w http.ResponseWriter, r *http.Request) {
 		Success: true,
 		Images:  make([]ImageResponse, 0),
 		Errors:  make([]string, 0),
+		Results: make([]UploadResult, 0, len(uploads)),
+	}
+	fail := func(filename string, err *uploadError) {
+		response.Errors = append(response.Errors, fmt.Sprintf("%s: %v", filename, err))
+		response.Results = append(response.Results, UploadResult{
+			Filename: filename,
+			Status:   err.status,
+			Code:     err.code,
+			Error:    err.message,
+		})
 	}
 
-	// Procesar cada imagen
-	for _, fileHeader := range files {
-		// Validar tamaño
-		if fileHeader.Size > maxFileSize {
-			response.Errors = append(response.Errors,
-				fmt.Sprintf("%s: excede tamaño máximo de 10MB", fileHeader.Filename))
-			continue
-		}
-
-		// Validar tipo de archivo
-		if !isValidImageType(fileHeader.Filename) {
-			response.Errors = append(response.Errors,
-				fmt.Sprintf("%s: formato no válido", fileHeader.Filename))
-			continue
-		}
-
-		file, err := fileHeader.Open()
-		if err != nil {
-			response.Errors = append(response.Errors,
-				fmt.Sprintf("%s: error abriendo archivo", fileHeader.Filename))
+	// Registrar cada imagen con su metadata, que pudo llegar después del
+	// archivo
+	usage = usageBefore
+	for i, upload := range uploads {
+		if upload.err != nil {
+			fail(upload.filename, upload.err)
 			continue
 		}
-		defer file.Close()
 
-		// Generar UUID
-		imageID := uuid.New().String()
-
-		// Obtener extensión
-		ext := filepath.Ext(fileHeader.Filename)
-		filename := imageID + ext
-		mimeType := getContentType(ext)
-
-		// Guardar imagen
-		destPath := filepath.Join(userDir, filename)
-		destFile, err := os.Create(destPath)
+		// Metadata opcional del archivo
+		meta, err := formImageMeta(metaParts, i, upload.filename)
 		if err != nil {
-			response.Errors = append(response.Errors,
-				fmt.Sprintf("%s: error guardando", fileHeader.Filename))
+			discardImage(upload.img)
+			fail(upload.filename, errInvalidMetadata(err))
 			continue
 		}
-		defer destFile.Close()
 
-		size, err := io.Copy(destFile, file)
+		img, err := commitImage(r.Context(), upload.img, meta)
 		if err != nil {
-			os.Remove(destPath) // Limpiar archivo incompleto
-			response.Errors = append(response.Errors,
-				fmt.Sprintf("%s: error escribiendo", fileHeader.Filename))
+			fail(upload.filename, asUploadError(err))
 			continue
 		}
 
-		// Guardar en BD
-		query := `INSERT INTO images (id, user_id, filename, file_path, mime_type, size_bytes) 
-				  VALUES (?, ?, ?, ?, ?, ?)`
-		_, err = db.Exec(query, imageID, userID, fileHeader.Filename, destPath, mimeType, size)
-		if err != nil {
-			os.Remove(destPath) // Limpiar archivo si falla BD
-			response.Errors = append(response.Errors,
-				fmt.Sprintf("%s: error guardando en BD", fileHeader.Filename))
-			log.Printf("Error BD: %v", err)
-			continue
-		}
+		usage += img.SizeBytes
+		count++
 
 		// Agregar a respuesta exitosa
-		response.Images = append(response.Images, ImageResponse{
-			ID:       imageID,
+		saved := ImageResponse{
+			ID:       img.ID,
 			UserID:   userID,
-			Filename: fileHeader.Filename,
-			Size:     size,
-			URL:      fmt.Sprintf("/image/%s/%s", userID, imageID),
+			Filename: img.Filename,
+			Size:     img.SizeBytes,
+			URL:      img.URL,
+		}
+		response.Images = append(response.Images, saved)
+		response.Results = append(response.Results, UploadResult{
+			Filename: upload.filename,
+			Status:   http.StatusCreated,
+			Image:    &saved,
 		})
-
-		log.Printf("✓ Imagen guardada: %s/%s (%d bytes)", userID, filename, size)
 	}
 
-	// Si todas fallaron
-	if len(response.Images) == 0 {
-		response.Success = false
-		w.WriteHeader(http.StatusBadRequest)
+	checkQuotaThresholds(w, userID, usageBefore, usage)
+	setImageLimitHeaders(w, count)
+
+	response.Status = uploadStatus(response.Results)
+	response.Success = len(response.Images) > 0
+	respondJSON(w, response.Status, response)
+}
+
+// pendingUpload es una parte images ya escrita (o rechazada) que espera
+// el final del formulario para registrarse
+type pendingUpload struct {
+	filename string
+	img      Image
+	err      *uploadError
+}
+
+// respondBodyError responde a un body que no se pudo leer entero
+func respondBodyError(w http.ResponseWriter, err error) {
+	if isTimeout(err) {
+		respondError(w, http.StatusRequestTimeout, "Tiempo de espera agotado recibiendo el formulario")
+		return
+	}
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		respondError(w, http.StatusRequestEntityTooLarge,
+			fmt.Sprintf("La petición excede el máximo de %s", formatSize(maxUploadSize)))
+		return
 	}
+	respondError(w, http.StatusBadRequest, "Error parseando formulario")
+}
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(response)
+// uploadStatus resume los resultados por archivo: 200 si todos se
+// guardaron, el status compartido si todos fallaron por lo mismo y 207
+// (Multi-Status) en cualquier otro caso
+func uploadStatus(results []UploadResult) int {
+	status := 0
+	for _, res := range results {
+		if status == 0 {
+			status = res.Status
+		} else if res.Status != status {
+			return http.StatusMultiStatus
+		}
+	}
+	if status == http.StatusCreated {
+		return http.StatusOK
+	}
+	return status
 }
 
 func downloadHandler(w http.ResponseWriter, r *http.Request) {
@@ -255,29 +626,41 @@ func downloadHandler(w http.ResponseWriter, r *http.Request) {
 	imageID := chi.URLParam(r, "id")
 
 	// Buscar en BD
-	var img Image
-	query := `SELECT id, user_id, filename, file_path, mime_type, size_bytes, created_at, deleted_at 
-			  FROM images WHERE id = ? AND user_id = ? AND deleted_at IS NULL`
-	err := db.QueryRow(query, imageID, userID).Scan(
-		&img.ID, &img.UserID, &img.Filename, &img.FilePath,
-		&img.MimeType, &img.SizeBytes, &img.CreatedAt, &img.DeletedAt,
-	)
-
+	img, err := findImage(r.Context(), userID, imageID)
 	if err == sql.ErrNoRows {
-		http.Error(w, "Imagen no encontrada", http.StatusNotFound)
+		respondError(w, http.StatusNotFound, "Imagen no encontrada")
 		return
 	}
 	if err != nil {
 		log.Printf("Error BD: %v", err)
-		http.Error(w, "Error interno", http.StatusInternalServerError)
+		respondError(w, http.StatusInternalServerError, "Error interno")
 		return
 	}
 
+	serveImageOrTransform(w, r, img)
+	log.Printf("✓ Imagen servida: %s/%s", userID, imageID)
+}
+
+// findImage busca una imagen no eliminada de un usuario
+func findImage(ctx context.Context, userID, imageID string) (Image, error) {
+	query := `SELECT ` + imageColumns + `
+			  FROM images WHERE id = ? AND user_id = ? AND deleted_at IS NULL`
+	return scanImage(db.QueryRowContext(ctx, query, imageID, userID))
+}
+
+// serveImage escribe el archivo de la imagen con headers de cache
+func serveImage(w http.ResponseWriter, r *http.Request, img Image) {
+	serveImageFile(w, r, img, generateETag(img.ID))
+}
+
+// serveImageFile sirve el archivo de img desde su backend; las renditions
+// lo usan con su propio archivo y ETag
+func serveImageFile(w http.ResponseWriter, r *http.Request, img Image, etag string) {
 	// Abrir archivo
-	file, err := os.Open(img.FilePath)
+	file, err := openImage(r.Context(), img)
 	if err != nil {
 		log.Printf("Error abriendo archivo: %v", err)
-		http.Error(w, "Error leyendo imagen", http.StatusInternalServerError)
+		respondError(w, http.StatusInternalServerError, "Error leyendo imagen")
 		return
 	}
 	defer file.Close()
@@ -285,10 +668,17 @@ func downloadHandler(w http.ResponseWriter, r *http.Request) {
 	// Headers
 	w.Header().Set("Content-Type", img.MimeType)
 	w.Header().Set("Content-Length", fmt.Sprintf("%d", img.SizeBytes))
-	w.Header().Set("Cache-Control", "public, max-age=31536000")
+	if w.Header().Get("Cache-Control") == "" {
+		w.Header().Set("Cache-Control", "public, max-age=31536000")
+	}
+
+	disposition := "inline"
+	if r.URL.Query().Get("download") == "true" {
+		disposition = "attachment"
+	}
+	w.Header().Set("Content-Disposition", contentDisposition(disposition, img.Filename))
 
 	// ETag para cache
-	etag := generateETag(imageID)
 	w.Header().Set("ETag", etag)
 
 	// Check if-none-match
@@ -298,17 +688,47 @@ func downloadHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Servir archivo
-	io.Copy(w, file)
-	log.Printf("✓ Imagen servida: %s/%s", userID, imageID)
+	n, _ := io.Copy(throttleDownload(w, r, img.SizeBytes), ctxReader{r.Context(), file})
+	recordBandwidth(img.UserID, n)
 }
 
 func listImagesHandler(w http.ResponseWriter, r *http.Request) {
 	userID := chi.URLParam(r, "userId")
 
-	query := `SELECT id, user_id, filename, file_path, mime_type, size_bytes, created_at 
-			  FROM images WHERE user_id = ? AND deleted_at IS NULL ORDER BY created_at DESC`
+	filters, args, err := parseListFilters(r.URL.Query())
+	if err != nil {
+		respondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	args = append([]interface{}{userID}, args...)
+
+	// Sin prefijo solo se pagina si se pide; en /v1 siempre
+	defaultLimit := 0
+	if envelopeOf(w) != nil {
+		defaultLimit = defaultPageLimit
+	}
+	limit, offset, err := parsePagination(r.URL.Query(), defaultLimit)
+	if err != nil {
+		respondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	where := ` FROM images WHERE user_id = ? AND deleted_at IS NULL` + filters
+	query := `SELECT ` + imageColumns + where + ` ORDER BY created_at DESC, id DESC`
+	var page *Pagination
+	if limit > 0 {
+		var total int
+		if err := db.QueryRowContext(r.Context(), `SELECT COUNT(*)`+where, args...).Scan(&total); err != nil {
+			log.Printf("Error BD: %v", err)
+			respondError(w, http.StatusInternalServerError, "Error consultando BD")
+			return
+		}
+		page = newPagination(limit, offset, total)
+		query += ` LIMIT ? OFFSET ?`
+		args = append(args, limit, offset)
+	}
 
-	rows, err := db.Query(query, userID)
+	rows, err := db.QueryContext(r.Context(), query, args...)
 	if err != nil {
 		log.Printf("Error BD: %v", err)
 		respondError(w, http.StatusInternalServerError, "Error consultando BD")
@@ -316,17 +736,31 @@ func listImagesHandler(w http.ResponseWriter, r *http.Request) {
 	}
 	defer rows.Close()
 
+	if acceptsNDJSON(r) {
+		streamImagesNDJSON(w, rows)
+		return
+	}
+
 	images := make([]Image, 0)
+	hinted := false
 	for rows.Next() {
-		var img Image
-		err := rows.Scan(&img.ID, &img.UserID, &img.Filename, &img.FilePath,
-			&img.MimeType, &img.SizeBytes, &img.CreatedAt)
+		img, err := scanImage(rows)
 		if err != nil {
 			log.Printf("Error escaneando fila: %v", err)
 			continue
 		}
 		img.URL = fmt.Sprintf("/image/%s/%s", img.UserID, img.ID)
 		images = append(images, img)
+
+		// Con las primeras filas ya se puede avisar al navegador, sin
+		// esperar a leer el resto del listado
+		if !hinted && len(images) == earlyHintsCount {
+			sendEarlyHints(w, r, images)
+			hinted = true
+		}
+	}
+	if !hinted {
+		sendEarlyHints(w, r, images)
 	}
 
 	response := ListResponse{
@@ -334,56 +768,152 @@ func listImagesHandler(w http.ResponseWriter, r *http.Request) {
 		Total:  len(images),
 		Images: images,
 	}
-
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(response)
+	// total es siempre el de todo el listado, no el de la página
+	if page != nil {
+		response.Total = page.Total
+	}
+	respondPage(w, http.StatusOK, response, page)
 }
 
 func deleteImageHandler(w http.ResponseWriter, r *http.Request) {
 	userID := chi.URLParam(r, "userId")
 	imageID := chi.URLParam(r, "id")
 
+	actor := requestActor(r, userID)
+	reason, err := deleteReason(r)
+	if err != nil {
+		respondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	// Con permanent=true se borra definitivamente, incluso si ya estaba en
+	// la papelera
+	if r.URL.Query().Get("permanent") == "true" {
+		query := `SELECT ` + imageColumns + ` FROM images WHERE id = ? AND user_id = ?`
+		img, err := scanImage(db.QueryRowContext(r.Context(), query, imageID, userID))
+		if err == sql.ErrNoRows {
+			respondError(w, http.StatusNotFound, "Imagen no encontrada")
+			return
+		}
+		if err == nil {
+			err = hardDeleteImage(r.Context(), img)
+		}
+		if err != nil {
+			log.Printf("Error BD: %v", err)
+			respondError(w, http.StatusInternalServerError, "Error eliminando imagen")
+			return
+		}
+		recordAudit(r.Context(), auditImagePurged, userID, imageID, actor, reason)
+
+		respondJSON(w, http.StatusOK, map[string]interface{}{
+			"success": true,
+			"message": "Imagen eliminada definitivamente",
+			"id":      imageID,
+		})
+		log.Printf("✓ Imagen eliminada (hard): %s/%s por %s", userID, imageID, actor.Subject)
+		return
+	}
+
 	// Soft delete
-	query := `UPDATE images SET deleted_at = NOW() WHERE id = ? AND user_id = ? AND deleted_at IS NULL`
-	result, err := db.Exec(query, imageID, userID)
+	deleted, err := softDeleteImage(r.Context(), userID, imageID, actor, reason)
 	if err != nil {
 		log.Printf("Error BD: %v", err)
 		respondError(w, http.StatusInternalServerError, "Error eliminando imagen")
 		return
 	}
-
-	affected, _ := result.RowsAffected()
-	if affected == 0 {
+	if !deleted {
 		respondError(w, http.StatusNotFound, "Imagen no encontrada")
 		return
 	}
+	recordAudit(r.Context(), auditImageDeleted, userID, imageID, actor, reason)
+
+	// Las renditions son cache: no tiene sentido conservarlas mientras la
+	// imagen no se puede servir
+	query := `SELECT ` + imageColumns + ` FROM images WHERE id = ?`
+	if img, err := scanImage(db.QueryRowContext(r.Context(), query, imageID)); err == nil {
+		if err := purgeRenditions(r.Context(), img); err != nil {
+			log.Printf("Error BD eliminando renditions de %s: %v", imageID, err)
+		}
+	}
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]interface{}{
+	respondJSON(w, http.StatusOK, map[string]interface{}{
 		"success": true,
 		"message": "Imagen eliminada",
 		"id":      imageID,
 	})
-	log.Printf("✓ Imagen eliminada (soft): %s/%s", userID, imageID)
+	log.Printf("✓ Imagen eliminada (soft): %s/%s por %s", userID, imageID, actor.Subject)
+}
+
+// softDeleteImage marca la imagen como eliminada y lo anota en el
+// changelog en la misma transacción
+func softDeleteImage(ctx context.Context, userID, imageID string, actor Actor, reason string) (bool, error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	query := `UPDATE images SET deleted_at = NOW(), deleted_by = ?, delete_reason = NULLIF(?, ''), delete_channel = ?
+			  WHERE id = ? AND user_id = ? AND deleted_at IS NULL`
+	result, err := tx.ExecContext(ctx, query, actor.Subject, reason, actor.Channel, imageID, userID)
+	if err != nil {
+		return false, err
+	}
+	if affected, _ := result.RowsAffected(); affected == 0 {
+		return false, nil
+	}
+	if err := recordChange(ctx, tx, userID, imageID, changeDeleted); err != nil {
+		return false, err
+	}
+	return true, tx.Commit()
 }
 
 func healthHandler(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Query().Get("deep") == "true" {
+		deepHealthHandler(w, r)
+		return
+	}
+
 	// Check BD
-	err := db.Ping()
+	err := db.PingContext(r.Context())
 	status := "ok"
 	if err != nil {
 		status = "degraded"
 		log.Printf("Health check: BD no disponible - %v", err)
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]string{
+	respondJSON(w, http.StatusOK, map[string]string{
 		"status":  status,
 		"service": "image-microservice",
 		"db":      status,
 	})
 }
 
+// readyHandler indica si la instancia puede recibir tráfico: BD accesible
+// y esquema en la versión esperada
+func readyHandler(w http.ResponseWriter, r *http.Request) {
+	if err := db.PingContext(r.Context()); err != nil {
+		log.Printf("Readiness: BD no disponible - %v", err)
+		respondJSON(w, http.StatusServiceUnavailable, map[string]string{
+			"status": "not_ready",
+			"error":  "BD no disponible",
+		})
+		return
+	}
+	if err := schemaError(); err != nil {
+		respondJSON(w, http.StatusServiceUnavailable, map[string]string{
+			"status": "not_ready",
+			"error":  err.Error(),
+		})
+		return
+	}
+
+	respondJSON(w, http.StatusOK, map[string]interface{}{
+		"status":         "ready",
+		"schema_version": latestSchemaVersion(),
+	})
+}
+
 func isValidImageType(filename string) bool {
 	ext := strings.ToLower(filepath.Ext(filename))
 	validExts := map[string]bool{
@@ -415,9 +945,64 @@ func generateETag(id string) string {
 	return fmt.Sprintf(`"%x"`, hash[:8])
 }
 
+// formatSize expresa bytes en la unidad entera más grande (10MB, 512KB)
+func formatSize(n int64) string {
+	switch {
+	case n >= 1<<30 && n%(1<<30) == 0:
+		return fmt.Sprintf("%dGB", n>>30)
+	case n >= 1<<20 && n%(1<<20) == 0:
+		return fmt.Sprintf("%dMB", n>>20)
+	case n >= 1<<10 && n%(1<<10) == 0:
+		return fmt.Sprintf("%dKB", n>>10)
+	}
+	return fmt.Sprintf("%d bytes", n)
+}
+
+func getEnv(key, fallback string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return fallback
+}
+
+func getEnvInt64(key string, fallback int64) int64 {
+	v := os.Getenv(key)
+	if v == "" {
+		return fallback
+	}
+	n, err := strconv.ParseInt(v, 10, 64)
+	if err != nil {
+		log.Fatalf("%s debe ser un entero: %v", key, err)
+	}
+	return n
+}
+
+func getEnvDuration(key string, fallback time.Duration) time.Duration {
+	v := os.Getenv(key)
+	if v == "" {
+		return fallback
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil || d <= 0 {
+		log.Fatalf("%s debe ser una duración válida (ej. 30m): %v", key, err)
+	}
+	return d
+}
+
+func respondJSON(w http.ResponseWriter, code int, v interface{}) {
+	respondPage(w, code, v, nil)
+}
+
 func respondError(w http.ResponseWriter, code int, message string) {
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(code)
+	if ew := envelopeOf(w); ew != nil {
+		json.NewEncoder(w).Encode(Envelope{
+			Error:     &EnvelopeError{Code: errorCode(code), Message: message},
+			RequestID: ew.requestID,
+		})
+		return
+	}
 	json.NewEncoder(w).Encode(map[string]string{
 		"error": message,
 	})
@@ -0,0 +1,273 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// maxBatchIDs limita cuántas imágenes se resuelven por petición
+const maxBatchIDs = 100
+
+type BatchMetadataRequest struct {
+	IDs []string `json:"ids"`
+}
+
+type BatchMetadataResponse struct {
+	UserID  string   `json:"user_id"`
+	Images  []Image  `json:"images"`
+	Missing []string `json:"missing"`
+}
+
+type ImageInfo struct {
+	Image
+	URLs map[string]string `json:"urls"`
+}
+
+// imageInfoHandler devuelve la metadata completa de una imagen sin el binario
+func imageInfoHandler(w http.ResponseWriter, r *http.Request) {
+	userID := chi.URLParam(r, "userId")
+	imageID := chi.URLParam(r, "id")
+
+	img, err := findImage(r.Context(), userID, imageID)
+	if err == sql.ErrNoRows {
+		respondError(w, http.StatusNotFound, "Imagen no encontrada")
+		return
+	}
+	if err != nil {
+		log.Printf("Error BD: %v", err)
+		respondError(w, http.StatusInternalServerError, "Error consultando BD")
+		return
+	}
+
+	info, err := buildImageInfo(r.Context(), img)
+	if err != nil {
+		log.Printf("Error BD: %v", err)
+		respondError(w, http.StatusInternalServerError, "Error consultando BD")
+		return
+	}
+	respondJSON(w, http.StatusOK, info)
+}
+
+// buildImageInfo arma las URLs de la imagen, incluidas las renditions ya
+// generadas ("rendition:<key>") y los slots que la apuntan ("slot:<nombre>")
+func buildImageInfo(ctx context.Context, img Image) (ImageInfo, error) {
+	base := fmt.Sprintf("/image/%s/%s", img.UserID, img.ID)
+	img.URL = base
+	info := ImageInfo{
+		Image: img,
+		URLs: map[string]string{
+			"original": base,
+			"info":     base + "/info",
+			"analysis": base + "/analysis",
+		},
+	}
+
+	keys, err := queryStrings(ctx, `SELECT rendition_key FROM renditions WHERE image_id = ?`, img.ID)
+	if err != nil {
+		return info, err
+	}
+	for _, key := range keys {
+		if p, ok := parseRenditionKey(key); ok {
+			info.URLs["rendition:"+key] = transformURL(base, p)
+		}
+	}
+
+	slots, err := queryStrings(ctx, `SELECT slot FROM image_slots WHERE user_id = ? AND image_id = ?`, img.UserID, img.ID)
+	if err != nil {
+		return info, err
+	}
+	for _, slot := range slots {
+		info.URLs["slot:"+slot] = slotURL(img.UserID, slot)
+	}
+	return info, nil
+}
+
+// queryStrings devuelve la primera columna de cada fila
+func queryStrings(ctx context.Context, query string, args ...interface{}) ([]string, error) {
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var values []string
+	for rows.Next() {
+		var v string
+		if err := rows.Scan(&v); err != nil {
+			return nil, err
+		}
+		values = append(values, v)
+	}
+	return values, rows.Err()
+}
+
+// batchMetadataHandler devuelve la metadata de varias imágenes en una sola
+// consulta, en el orden pedido; las inexistentes o eliminadas van en missing
+func batchMetadataHandler(w http.ResponseWriter, r *http.Request) {
+	userID := chi.URLParam(r, "userId")
+
+	var req BatchMetadataRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondError(w, http.StatusBadRequest, "JSON inválido")
+		return
+	}
+
+	ids := uniqueStrings(req.IDs)
+	if len(ids) == 0 {
+		respondError(w, http.StatusBadRequest, "ids es requerido")
+		return
+	}
+	if len(ids) > maxBatchIDs {
+		respondError(w, http.StatusBadRequest, fmt.Sprintf("Máximo %d ids por petición", maxBatchIDs))
+		return
+	}
+
+	found, err := findImagesByID(r.Context(), userID, ids)
+	if err != nil {
+		log.Printf("Error BD: %v", err)
+		respondError(w, http.StatusInternalServerError, "Error consultando BD")
+		return
+	}
+
+	response := BatchMetadataResponse{
+		UserID:  userID,
+		Images:  make([]Image, 0, len(found)),
+		Missing: make([]string, 0),
+	}
+	for _, id := range ids {
+		if img, ok := found[id]; ok {
+			response.Images = append(response.Images, img)
+		} else {
+			response.Missing = append(response.Missing, id)
+		}
+	}
+
+	respondJSON(w, http.StatusOK, response)
+}
+
+// findImagesByID busca imágenes no eliminadas de un usuario por id
+func findImagesByID(ctx context.Context, userID string, ids []string) (map[string]Image, error) {
+	args := make([]interface{}, 0, len(ids)+1)
+	args = append(args, userID)
+	for _, id := range ids {
+		args = append(args, id)
+	}
+
+	query := `SELECT ` + imageColumns + ` FROM images
+			  WHERE user_id = ? AND deleted_at IS NULL AND id IN (` + placeholders(len(ids)) + `)`
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	found := make(map[string]Image, len(ids))
+	for rows.Next() {
+		img, err := scanImage(rows)
+		if err != nil {
+			return nil, err
+		}
+		img.URL = fmt.Sprintf("/image/%s/%s", img.UserID, img.ID)
+		found[img.ID] = img
+	}
+	return found, rows.Err()
+}
+
+// placeholders arma "?, ?, ?" para cláusulas IN
+func placeholders(n int) string {
+	if n <= 0 {
+		return ""
+	}
+	return strings.Repeat("?, ", n-1) + "?"
+}
+
+// uniqueStrings quita vacíos y duplicados conservando el orden
+func uniqueStrings(values []string) []string {
+	seen := make(map[string]bool, len(values))
+	result := make([]string, 0, len(values))
+	for _, v := range values {
+		if v == "" || seen[v] {
+			continue
+		}
+		seen[v] = true
+		result = append(result, v)
+	}
+	return result
+}
+
+type HashLookupResponse struct {
+	UserID   string      `json:"user_id"`
+	Checksum string      `json:"checksum"`
+	Images   []ImageInfo `json:"images"`
+}
+
+// hashLookupHandler permite al cliente saber si un contenido ya existe
+// antes de subirlo; 404 si el usuario no tiene ninguna imagen con ese hash
+func hashLookupHandler(w http.ResponseWriter, r *http.Request) {
+	userID := chi.URLParam(r, "userId")
+	checksum := strings.ToLower(chi.URLParam(r, "sha256"))
+	if !isSHA256Hex(checksum) {
+		respondError(w, http.StatusBadRequest, "sha256 debe ser un hash de 64 caracteres hex")
+		return
+	}
+
+	query := `SELECT ` + imageColumns + ` FROM images
+			  WHERE user_id = ? AND checksum = ? AND deleted_at IS NULL ORDER BY created_at`
+	rows, err := db.QueryContext(r.Context(), query, userID, checksum)
+	if err != nil {
+		log.Printf("Error BD: %v", err)
+		respondError(w, http.StatusInternalServerError, "Error consultando BD")
+		return
+	}
+	defer rows.Close()
+
+	response := HashLookupResponse{
+		UserID:   userID,
+		Checksum: checksum,
+		Images:   make([]ImageInfo, 0),
+	}
+	var images []Image
+	for rows.Next() {
+		img, err := scanImage(rows)
+		if err != nil {
+			log.Printf("Error escaneando fila: %v", err)
+			continue
+		}
+		images = append(images, img)
+	}
+	rows.Close()
+
+	for _, img := range images {
+		info, err := buildImageInfo(r.Context(), img)
+		if err != nil {
+			log.Printf("Error BD: %v", err)
+			respondError(w, http.StatusInternalServerError, "Error consultando BD")
+			return
+		}
+		response.Images = append(response.Images, info)
+	}
+
+	if len(response.Images) == 0 {
+		respondError(w, http.StatusNotFound, "No existe una imagen con ese hash")
+		return
+	}
+	respondJSON(w, http.StatusOK, response)
+}
+
+func isSHA256Hex(s string) bool {
+	if len(s) != 64 {
+		return false
+	}
+	for _, c := range s {
+		if (c < '0' || c > '9') && (c < 'a' || c > 'f') {
+			return false
+		}
+	}
+	return true
+}
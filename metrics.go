@@ -0,0 +1,48 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"sync/atomic"
+)
+
+// Métricas mínimas en formato de texto de Prometheus
+
+type metric struct {
+	name  string
+	help  string
+	kind  string // counter | gauge
+	value atomic.Int64
+}
+
+var (
+	metricsMu       sync.Mutex
+	metricsRegistry []*metric
+)
+
+func newMetric(kind, name, help string) *metric {
+	m := &metric{name: name, help: help, kind: kind}
+	metricsMu.Lock()
+	metricsRegistry = append(metricsRegistry, m)
+	metricsMu.Unlock()
+	return m
+}
+
+func newCounter(name, help string) *metric { return newMetric("counter", name, help) }
+
+func newGauge(name, help string) *metric { return newMetric("gauge", name, help) }
+
+func (m *metric) Add(n int64) { m.value.Add(n) }
+
+func (m *metric) Set(n int64) { m.value.Store(n) }
+
+func metricsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+
+	metricsMu.Lock()
+	defer metricsMu.Unlock()
+	for _, m := range metricsRegistry {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n%s %d\n", m.name, m.help, m.name, m.kind, m.name, m.value.Load())
+	}
+}
@@ -0,0 +1,415 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+const (
+	migrationLockName    = "image_api_migrations"
+	migrationLockTimeout = 60 // segundos
+	schemaCheckInterval  = 15 * time.Second
+)
+
+// schemaStatus guarda el resultado de la última verificación del esquema;
+// mientras tenga error el servicio no está listo
+var schemaStatus struct {
+	sync.RWMutex
+	err error
+}
+
+func setSchemaStatus(err error) {
+	schemaStatus.Lock()
+	schemaStatus.err = err
+	schemaStatus.Unlock()
+}
+
+func schemaError() error {
+	schemaStatus.RLock()
+	defer schemaStatus.RUnlock()
+	return schemaStatus.err
+}
+
+type migration struct {
+	version int
+	name    string
+	queries []string
+}
+
+// migrations se aplican en orden y una sola vez; nunca modificar una
+// migración ya publicada, agregar una nueva al final.
+var migrations = []migration{
+	{1, "crear tabla images", []string{`
+	CREATE TABLE IF NOT EXISTS images (
+		id VARCHAR(36) PRIMARY KEY,
+		user_id VARCHAR(100) NOT NULL,
+		filename VARCHAR(255) NOT NULL,
+		file_path VARCHAR(500) NOT NULL,
+		mime_type VARCHAR(50) NOT NULL,
+		size_bytes BIGINT NOT NULL,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		deleted_at TIMESTAMP NULL,
+		INDEX idx_user_id (user_id),
+		INDEX idx_created_at (created_at),
+		INDEX idx_deleted_at (deleted_at)
+	) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+	`}},
+	{2, "crear tabla share_links", []string{`
+	CREATE TABLE IF NOT EXISTS share_links (
+		token VARCHAR(64) PRIMARY KEY,
+		image_id VARCHAR(36) NOT NULL,
+		user_id VARCHAR(100) NOT NULL,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		expires_at TIMESTAMP NULL,
+		INDEX idx_image_id (image_id)
+	) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+	`}},
+	{3, "agregar dimensiones a images", []string{
+		`ALTER TABLE images ADD COLUMN width INT NULL, ADD COLUMN height INT NULL`,
+		`CREATE INDEX idx_user_dimensions ON images (user_id, width, height)`,
+	}},
+	{4, "crear tabla usage_daily", []string{`
+	CREATE TABLE IF NOT EXISTS usage_daily (
+		user_id VARCHAR(100) NOT NULL,
+		day DATE NOT NULL,
+		bytes_served BIGINT NOT NULL DEFAULT 0,
+		requests BIGINT NOT NULL DEFAULT 0,
+		PRIMARY KEY (user_id, day),
+		INDEX idx_day (day)
+	) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+	`}},
+	{5, "agregar volume a images", []string{
+		`ALTER TABLE images ADD COLUMN volume VARCHAR(100) NULL`,
+	}},
+	{6, "crear tabla pending_uploads", []string{`
+	CREATE TABLE IF NOT EXISTS pending_uploads (
+		image_id VARCHAR(36) PRIMARY KEY,
+		user_id VARCHAR(100) NOT NULL,
+		file_path VARCHAR(500) NOT NULL,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		INDEX idx_created_at (created_at)
+	) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+	`}},
+	{7, "agregar checksum a images", []string{
+		`ALTER TABLE images ADD COLUMN checksum CHAR(64) NULL`,
+		`CREATE INDEX idx_user_checksum ON images (user_id, checksum)`,
+	}},
+	{8, "crear tabla image_slots", []string{`
+	CREATE TABLE IF NOT EXISTS image_slots (
+		user_id VARCHAR(100) NOT NULL,
+		slot VARCHAR(50) NOT NULL,
+		image_id VARCHAR(36) NOT NULL,
+		updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+		PRIMARY KEY (user_id, slot),
+		INDEX idx_image_id (image_id)
+	) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+	`}},
+	{9, "crear tabla storage_migrations", []string{`
+	CREATE TABLE IF NOT EXISTS storage_migrations (
+		id VARCHAR(36) PRIMARY KEY,
+		source VARCHAR(100) NOT NULL,
+		target VARCHAR(100) NOT NULL,
+		status VARCHAR(20) NOT NULL,
+		copied INT NOT NULL DEFAULT 0,
+		failed INT NOT NULL DEFAULT 0,
+		bytes BIGINT NOT NULL DEFAULT 0,
+		error TEXT NULL,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+		INDEX idx_status (status)
+	) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+	`}},
+	{10, "agregar caption, album y metadata a images", []string{
+		`ALTER TABLE images ADD COLUMN caption TEXT NULL, ADD COLUMN album_id VARCHAR(100) NULL, ADD COLUMN metadata JSON NULL`,
+		`CREATE INDEX idx_user_album ON images (user_id, album_id)`,
+		`CREATE TABLE IF NOT EXISTS image_tags (
+			image_id VARCHAR(36) NOT NULL,
+			user_id VARCHAR(100) NOT NULL,
+			tag VARCHAR(40) NOT NULL,
+			PRIMARY KEY (image_id, tag),
+			INDEX idx_user_tag (user_id, tag)
+		) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4`,
+	}},
+	{11, "crear tabla renditions", []string{`
+	CREATE TABLE IF NOT EXISTS renditions (
+		image_id VARCHAR(36) NOT NULL,
+		rendition_key VARCHAR(50) NOT NULL,
+		file_path VARCHAR(500) NOT NULL,
+		size_bytes BIGINT NOT NULL,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		PRIMARY KEY (image_id, rendition_key)
+	) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+	`}},
+	{12, "registrar autor y motivo de eliminación", []string{
+		`ALTER TABLE images ADD COLUMN deleted_by VARCHAR(150) NULL, ADD COLUMN delete_reason VARCHAR(500) NULL,
+			ADD COLUMN delete_channel VARCHAR(50) NULL`,
+		`CREATE TABLE IF NOT EXISTS audit_log (
+			id BIGINT AUTO_INCREMENT PRIMARY KEY,
+			action VARCHAR(50) NOT NULL,
+			user_id VARCHAR(100) NOT NULL,
+			image_id VARCHAR(36) NULL,
+			actor VARCHAR(150) NOT NULL,
+			channel VARCHAR(50) NOT NULL,
+			reason VARCHAR(500) NULL,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			INDEX idx_user_created (user_id, created_at),
+			INDEX idx_image (image_id)
+		) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4`,
+	}},
+	{13, "crear tablas de suscripciones de webhooks", []string{
+		`CREATE TABLE IF NOT EXISTS webhook_subscriptions (
+			id VARCHAR(36) PRIMARY KEY,
+			user_id VARCHAR(100) NOT NULL,
+			url VARCHAR(1000) NOT NULL,
+			secret VARCHAR(100) NOT NULL,
+			events VARCHAR(500) NOT NULL DEFAULT '',
+			status VARCHAR(20) NOT NULL DEFAULT 'active',
+			failure_count INT NOT NULL DEFAULT 0,
+			last_success_at TIMESTAMP NULL,
+			last_failure_at TIMESTAMP NULL,
+			last_error TEXT NULL,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			INDEX idx_user_status (user_id, status)
+		) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4`,
+		`CREATE TABLE IF NOT EXISTS webhook_deliveries (
+			id BIGINT AUTO_INCREMENT PRIMARY KEY,
+			subscription_id VARCHAR(36) NOT NULL,
+			event_id VARCHAR(36) NOT NULL,
+			event_type VARCHAR(100) NOT NULL,
+			attempt INT NOT NULL,
+			success BOOLEAN NOT NULL,
+			status_code INT NULL,
+			error TEXT NULL,
+			duration_ms INT NOT NULL,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			INDEX idx_subscription (subscription_id, id)
+		) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4`,
+	}},
+	{14, "crear tabla image_changes", []string{`
+	CREATE TABLE IF NOT EXISTS image_changes (
+		seq BIGINT AUTO_INCREMENT PRIMARY KEY,
+		user_id VARCHAR(100) NOT NULL,
+		image_id VARCHAR(36) NOT NULL,
+		op VARCHAR(20) NOT NULL,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		INDEX idx_user_seq (user_id, seq)
+	) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+	`}},
+	{15, "seq del changelog por usuario", []string{
+		`CREATE TABLE IF NOT EXISTS user_counters (
+			user_id VARCHAR(100) PRIMARY KEY,
+			change_seq BIGINT NOT NULL DEFAULT 0
+		) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4`,
+		`INSERT INTO user_counters (user_id, change_seq) SELECT user_id, MAX(seq) FROM image_changes GROUP BY user_id`,
+		`ALTER TABLE image_changes MODIFY seq BIGINT NOT NULL, DROP PRIMARY KEY, ADD PRIMARY KEY (user_id, seq), DROP INDEX idx_user_seq`,
+	}},
+	{16, "backend de las subidas pendientes", []string{
+		`ALTER TABLE pending_uploads ADD COLUMN volume VARCHAR(100) NULL`,
+	}},
+	// Nombre visible desambiguado, elegido al subir. Las filas existentes
+	// se numeran por orden de creación entre las de igual nombre.
+	{17, "nombre visible de las imágenes", []string{
+		`ALTER TABLE images ADD COLUMN display_name VARCHAR(300) NULL AFTER filename,
+			ADD INDEX idx_user_display_name (user_id, display_name)`,
+		`UPDATE images i JOIN (
+			SELECT id, ROW_NUMBER() OVER (PARTITION BY user_id, LOWER(filename) ORDER BY created_at, id) - 1 AS n,
+				IF(filename LIKE '%_.%', CONCAT('.', SUBSTRING_INDEX(filename, '.', -1)), '') AS ext
+			FROM images WHERE deleted_at IS NULL
+		) d ON d.id = i.id
+		SET i.display_name = IF(d.n = 0, i.filename,
+			CONCAT(LEFT(i.filename, CHAR_LENGTH(i.filename) - CHAR_LENGTH(d.ext)), ' (', d.n, ')', d.ext))`,
+	}},
+	// Lápidas de las imágenes borradas definitivamente, para que el
+	// reporte de uso siga contando sus subidas y eliminaciones
+	{18, "crear tabla purged_images", []string{`
+	CREATE TABLE IF NOT EXISTS purged_images (
+		image_id VARCHAR(36) PRIMARY KEY,
+		user_id VARCHAR(100) NOT NULL,
+		size_bytes BIGINT NOT NULL,
+		created_at TIMESTAMP NOT NULL,
+		deleted_at TIMESTAMP NOT NULL,
+		purged_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		INDEX idx_created_at (created_at),
+		INDEX idx_deleted_at (deleted_at)
+	) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+	`}},
+	{19, "latido de las subidas pendientes", []string{
+		`ALTER TABLE pending_uploads ADD COLUMN touched_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			ADD INDEX idx_touched_at (touched_at)`,
+	}},
+}
+
+// runMigrations aplica las migraciones pendientes. Toma un lock con nombre
+// en MySQL para que varias réplicas arrancando a la vez no corran el DDL en
+// paralelo; la que espera encuentra el esquema ya actualizado.
+func runMigrations() error {
+	ctx := context.Background()
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	var locked sql.NullInt64
+	if err := conn.QueryRowContext(ctx, `SELECT GET_LOCK(?, ?)`, migrationLockName, migrationLockTimeout).Scan(&locked); err != nil {
+		return fmt.Errorf("tomando lock de migraciones: %w", err)
+	}
+	if locked.Int64 != 1 {
+		return fmt.Errorf("timeout esperando lock de migraciones")
+	}
+	defer conn.ExecContext(ctx, `SELECT RELEASE_LOCK(?)`, migrationLockName)
+
+	query := `
+	CREATE TABLE IF NOT EXISTS schema_migrations (
+		version INT PRIMARY KEY,
+		name VARCHAR(255) NOT NULL,
+		applied_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+	) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+	`
+	if _, err := conn.ExecContext(ctx, query); err != nil {
+		return fmt.Errorf("tabla schema_migrations: %w", err)
+	}
+
+	current, err := schemaVersion(conn)
+	if err != nil {
+		return err
+	}
+
+	for _, m := range migrations {
+		if m.version <= current {
+			continue
+		}
+		for _, q := range m.queries {
+			if _, err := conn.ExecContext(ctx, q); err != nil {
+				return fmt.Errorf("migración %d (%s): %w", m.version, m.name, err)
+			}
+		}
+		if _, err := conn.ExecContext(ctx, `INSERT INTO schema_migrations (version, name) VALUES (?, ?)`, m.version, m.name); err != nil {
+			return fmt.Errorf("registrando migración %d: %w", m.version, err)
+		}
+		log.Printf("✅ Migración %d aplicada: %s", m.version, m.name)
+	}
+
+	log.Printf("✅ Esquema en versión %d", latestSchemaVersion())
+	return nil
+}
+
+type rowQueryer interface {
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+// schemaVersion devuelve la última migración aplicada en la BD
+func schemaVersion(q rowQueryer) (int, error) {
+	var version int
+	err := q.QueryRowContext(context.Background(), `SELECT COALESCE(MAX(version), 0) FROM schema_migrations`).Scan(&version)
+	if err != nil {
+		return 0, fmt.Errorf("leyendo versión de esquema: %w", err)
+	}
+	return version, nil
+}
+
+// verifySchema se usa con DB_AUTO_MIGRATE=false: no ejecuta DDL, solo
+// comprueba que alguien haya aplicado las migraciones que este binario espera
+func verifySchema() error {
+	version, err := schemaVersion(db)
+	if err != nil {
+		return fmt.Errorf("esquema no inicializado (¿falta correr -migrate?): %w", err)
+	}
+	if version < latestSchemaVersion() {
+		return fmt.Errorf("esquema en versión %d, se requiere %d: correr -migrate o aplicar las migraciones pendientes",
+			version, latestSchemaVersion())
+	}
+	return nil
+}
+
+// waitForSchema verifica el esquema periódicamente hasta que esté listo,
+// marcando el servicio como no listo mientras tanto
+func waitForSchema(onReady func()) {
+	for {
+		err := verifySchema()
+		setSchemaStatus(err)
+		if err == nil {
+			log.Printf("✅ Esquema verificado en versión %d", latestSchemaVersion())
+			onReady()
+			return
+		}
+		log.Printf("⚠️  %v", err)
+		time.Sleep(schemaCheckInterval)
+	}
+}
+
+func latestSchemaVersion() int {
+	return migrations[len(migrations)-1].version
+}
+
+// backfillDimensions completa width/height de imágenes subidas antes de la
+// migración 3. Las que no se pueden decodificar quedan en 0x0 para no
+// reintentarlas en cada arranque.
+func backfillDimensions() {
+	backfillImages("dimensiones", "width IS NULL", "width = ?, height = ?", func(path string) ([]interface{}, error) {
+		width, height, err := imageDimensions(path)
+		return []interface{}{width, height}, err
+	})
+}
+
+// backfillChecksums completa el sha256 de imágenes anteriores a la
+// migración 7; si el archivo no se puede leer queda vacío
+func backfillChecksums() {
+	backfillImages("checksums", "checksum IS NULL", "checksum = ?", func(path string) ([]interface{}, error) {
+		sum, err := fileChecksum(path)
+		return []interface{}{sum}, err
+	})
+}
+
+// backfillImages recorre por lotes las imágenes que cumplen where y les
+// asigna los valores que calcula compute a partir del archivo. compute debe
+// devolver valores de relleno aun con error para que la fila no vuelva a
+// seleccionarse.
+func backfillImages(what, where, set string, compute func(path string) ([]interface{}, error)) {
+	const batchSize = 500
+	total := 0
+	for {
+		rows, err := db.Query(`SELECT id, file_path FROM images WHERE `+where+` LIMIT ?`, batchSize)
+		if err != nil {
+			log.Printf("Error BD en backfill de %s: %v", what, err)
+			return
+		}
+
+		type pending struct{ id, path string }
+		batch := make([]pending, 0, batchSize)
+		for rows.Next() {
+			var p pending
+			if err := rows.Scan(&p.id, &p.path); err != nil {
+				log.Printf("Error escaneando fila: %v", err)
+				continue
+			}
+			batch = append(batch, p)
+		}
+		rows.Close()
+
+		if len(batch) == 0 {
+			break
+		}
+		// Un backfill largo corre dentro de startMaintenance
+		workerBeat("maintenance")
+		for _, p := range batch {
+			values, err := compute(p.path)
+			if err != nil {
+				log.Printf("Backfill de %s: no se pudo leer %s: %v", what, p.path, err)
+			}
+			if _, err := db.Exec(`UPDATE images SET `+set+` WHERE id = ?`, append(values, p.id)...); err != nil {
+				log.Printf("Error BD en backfill de %s: %v", what, err)
+				return
+			}
+		}
+		total += len(batch)
+	}
+
+	if total > 0 {
+		log.Printf("✅ Backfill de %s completado para %d imágenes", what, total)
+	}
+}
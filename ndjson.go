@@ -0,0 +1,58 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"mime"
+	"net/http"
+	"strings"
+)
+
+const (
+	ndjsonContentType = "application/x-ndjson"
+	ndjsonFlushEvery  = 100
+)
+
+func acceptsNDJSON(r *http.Request) bool {
+	for _, part := range strings.Split(r.Header.Get("Accept"), ",") {
+		if mt, _, err := mime.ParseMediaType(strings.TrimSpace(part)); err == nil && mt == ndjsonContentType {
+			return true
+		}
+	}
+	return false
+}
+
+// streamImagesNDJSON escribe una imagen por línea a medida que se leen las
+// filas, sin armar el listado completo en memoria. Si la consulta falla a
+// mitad de camino ya no se puede cambiar el status: se corta el stream y
+// queda en el log.
+func streamImagesNDJSON(w http.ResponseWriter, rows *sql.Rows) {
+	w.Header().Set("Content-Type", ndjsonContentType)
+	w.WriteHeader(http.StatusOK)
+
+	flusher, _ := w.(http.Flusher)
+	enc := json.NewEncoder(w)
+	count := 0
+	for rows.Next() {
+		img, err := scanImage(rows)
+		if err != nil {
+			log.Printf("Error escaneando fila: %v", err)
+			continue
+		}
+		img.URL = fmt.Sprintf("/image/%s/%s", img.UserID, img.ID)
+		if err := enc.Encode(img); err != nil {
+			log.Printf("Error escribiendo NDJSON: %v", err)
+			return
+		}
+
+		count++
+		if flusher != nil && count%ndjsonFlushEvery == 0 {
+			flusher.Flush()
+		}
+	}
+	if err := rows.Err(); err != nil {
+		log.Printf("Error BD durante stream NDJSON: %v", err)
+	}
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"database/sql"
+	"html/template"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+)
+
+const oembedProvider = "image-api"
+
+var previewTemplate = template.Must(template.New("preview").Parse(`<!DOCTYPE html>
+<html lang="es">
+<head>
+<meta charset="utf-8">
+<title>{{.Title}}</title>
+<meta property="og:type" content="website">
+<meta property="og:site_name" content="{{.Provider}}">
+<meta property="og:title" content="{{.Title}}">
+<meta property="og:url" content="{{.PageURL}}">
+<meta property="og:image" content="{{.ImageURL}}">
+<meta property="og:image:type" content="{{.MimeType}}">
+{{- if .Width}}
+<meta property="og:image:width" content="{{.Width}}">
+<meta property="og:image:height" content="{{.Height}}">
+{{- end}}
+<meta name="twitter:card" content="summary_large_image">
+<meta name="twitter:title" content="{{.Title}}">
+<meta name="twitter:image" content="{{.ImageURL}}">
+<link rel="alternate" type="application/json+oembed" href="{{.OEmbedURL}}" title="{{.Title}}">
+</head>
+<body>
+<img src="{{.ImageURL}}" alt="{{.Title}}"{{if .Width}} width="{{.Width}}" height="{{.Height}}"{{end}}>
+</body>
+</html>
+`))
+
+type previewData struct {
+	Title     string
+	Provider  string
+	PageURL   string
+	ImageURL  string
+	OEmbedURL string
+	MimeType  string
+	Width     int
+	Height    int
+}
+
+type OEmbedResponse struct {
+	Type         string `json:"type"`
+	Version      string `json:"version"`
+	Title        string `json:"title"`
+	ProviderName string `json:"provider_name"`
+	ProviderURL  string `json:"provider_url"`
+	URL          string `json:"url"`
+	Width        int    `json:"width"`
+	Height       int    `json:"height"`
+}
+
+// previewHandler sirve una página HTML con tags OpenGraph para que los
+// enlaces compartidos se vean con vista previa en Slack y redes sociales
+func previewHandler(w http.ResponseWriter, r *http.Request) {
+	token := chi.URLParam(r, "token")
+	img, err := findSharedImage(r.Context(), token)
+	if err == sql.ErrNoRows {
+		respondError(w, http.StatusNotFound, "Enlace no encontrado")
+		return
+	}
+	if err != nil {
+		log.Printf("Error BD: %v", err)
+		respondError(w, http.StatusInternalServerError, "Error interno")
+		return
+	}
+
+	data := previewData{
+		Title:     img.Filename,
+		Provider:  oembedProvider,
+		PageURL:   previewURL(r, token),
+		ImageURL:  shareURL(r, token),
+		OEmbedURL: baseURL(r) + "/oembed?format=json&url=" + url.QueryEscape(previewURL(r, token)),
+		MimeType:  img.MimeType,
+	}
+	if width, height, err := storedDimensions(r.Context(), img); err == nil {
+		data.Width, data.Height = width, height
+	} else {
+		log.Printf("Error leyendo dimensiones: %v", err)
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	if err := previewTemplate.Execute(w, data); err != nil {
+		log.Printf("Error renderizando preview: %v", err)
+	}
+}
+
+// oembedHandler implementa el endpoint oEmbed (tipo "photo") para URLs
+// de enlaces compartidos o de su página de preview
+func oembedHandler(w http.ResponseWriter, r *http.Request) {
+	if format := r.URL.Query().Get("format"); format != "" && format != "json" {
+		respondError(w, http.StatusNotImplemented, "Solo se soporta format=json")
+		return
+	}
+
+	token, ok := shareTokenFromURL(r.URL.Query().Get("url"))
+	if !ok {
+		respondError(w, http.StatusNotFound, "URL no soportada")
+		return
+	}
+
+	img, err := findSharedImage(r.Context(), token)
+	if err == sql.ErrNoRows {
+		respondError(w, http.StatusNotFound, "Enlace no encontrado")
+		return
+	}
+	if err != nil {
+		log.Printf("Error BD: %v", err)
+		respondError(w, http.StatusInternalServerError, "Error consultando BD")
+		return
+	}
+
+	width, height, err := storedDimensions(r.Context(), img)
+	if err != nil {
+		log.Printf("Error leyendo dimensiones: %v", err)
+		respondError(w, http.StatusInternalServerError, "Error leyendo imagen")
+		return
+	}
+
+	// Respetar maxwidth/maxheight manteniendo la proporción
+	scale := 1.0
+	if mw, err := strconv.Atoi(r.URL.Query().Get("maxwidth")); err == nil && mw > 0 && width > mw {
+		scale = float64(mw) / float64(width)
+	}
+	if mh, err := strconv.Atoi(r.URL.Query().Get("maxheight")); err == nil && mh > 0 && float64(height)*scale > float64(mh) {
+		scale = float64(mh) / float64(height)
+	}
+
+	respondJSON(w, http.StatusOK, OEmbedResponse{
+		Type:         "photo",
+		Version:      "1.0",
+		Title:        img.Filename,
+		ProviderName: oembedProvider,
+		ProviderURL:  baseURL(r),
+		URL:          shareURL(r, token),
+		Width:        int(float64(width) * scale),
+		Height:       int(float64(height) * scale),
+	})
+}
+
+func previewURL(r *http.Request, token string) string {
+	return baseURL(r) + "/p/" + token
+}
+
+// shareTokenFromURL extrae el token de URLs /s/{token} o /p/{token}
+func shareTokenFromURL(raw string) (string, bool) {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return "", false
+	}
+	parts := strings.Split(strings.Trim(u.Path, "/"), "/")
+	if len(parts) != 2 || (parts[0] != "s" && parts[0] != "p") || parts[1] == "" {
+		return "", false
+	}
+	return parts[1], true
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// trustedProxies (TRUSTED_PROXIES) son los rangos cuyos headers de
+// reenvío se aceptan; vacío significa que no se confía en ninguno
+var trustedProxies []*net.IPNet
+
+// parseTrustedProxies lee una lista separada por comas de CIDRs o IPs sueltas
+func parseTrustedProxies(s string) ([]*net.IPNet, error) {
+	var nets []*net.IPNet
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		if !strings.Contains(part, "/") {
+			ip := net.ParseIP(part)
+			if ip == nil {
+				return nil, fmt.Errorf("IP inválida %q", part)
+			}
+			bits := 32
+			if ip.To4() == nil {
+				bits = 128
+			}
+			part = fmt.Sprintf("%s/%d", part, bits)
+		}
+		_, ipNet, err := net.ParseCIDR(part)
+		if err != nil {
+			return nil, fmt.Errorf("CIDR inválido %q", part)
+		}
+		nets = append(nets, ipNet)
+	}
+	return nets, nil
+}
+
+func isTrustedProxy(ip net.IP) bool {
+	for _, n := range trustedProxies {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// realIP reemplaza a middleware.RealIP: solo usa X-Forwarded-For/X-Real-IP
+// si la conexión viene de un proxy de confianza. Si no, descarta los headers
+// de reenvío para que nada más adelante los tome en cuenta; los del autor
+// (X-Actor-ID, X-Client-Channel) se respetan también con el token de admin.
+func realIP(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		remote := net.ParseIP(clientIP(r))
+		if remote == nil || !isTrustedProxy(remote) {
+			r.Header.Del("X-Forwarded-For")
+			r.Header.Del("X-Real-IP")
+			r.Header.Del("X-Forwarded-Proto")
+			if !isAdminRequest(r) {
+				r.Header.Del("X-Actor-ID")
+				r.Header.Del("X-Client-Channel")
+			}
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if ip := forwardedClientIP(r); ip != "" {
+			r.RemoteAddr = ip
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// forwardedClientIP recorre X-Forwarded-For de derecha a izquierda y
+// devuelve la primera IP que no es un proxy de confianza. Las entradas más a
+// la izquierda las pone el cliente y no son fiables.
+func forwardedClientIP(r *http.Request) string {
+	var hops []string
+	for _, h := range r.Header.Values("X-Forwarded-For") {
+		hops = append(hops, strings.Split(h, ",")...)
+	}
+	for i := len(hops) - 1; i >= 0; i-- {
+		ip := net.ParseIP(strings.TrimSpace(hops[i]))
+		if ip == nil {
+			return "" // cadena malformada: quedarse con RemoteAddr
+		}
+		if !isTrustedProxy(ip) {
+			return ip.String()
+		}
+	}
+	if ip := net.ParseIP(strings.TrimSpace(r.Header.Get("X-Real-IP"))); ip != nil {
+		return ip.String()
+	}
+	return ""
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestParseTrustedProxies(t *testing.T) {
+	nets, err := parseTrustedProxies(" 10.0.0.0/8, 192.0.2.7 ,::1,")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"10.0.0.0/8", "192.0.2.7/32", "::1/128"}
+	if len(nets) != len(want) {
+		t.Fatalf("%d redes, se esperaban %d", len(nets), len(want))
+	}
+	for i, n := range nets {
+		if n.String() != want[i] {
+			t.Errorf("red %d = %s, se esperaba %s", i, n, want[i])
+		}
+	}
+
+	for _, bad := range []string{"10.0.0.0/33", "proxy.local", "10.0.0"} {
+		if _, err := parseTrustedProxies(bad); err == nil {
+			t.Errorf("%q debería ser inválido", bad)
+		}
+	}
+}
+
+// realIPRequest pasa una petición por realIP y devuelve lo que ve el handler
+func realIPRequest(t *testing.T, remote string, headers map[string]string) *http.Request {
+	var seen *http.Request
+	handler := realIP(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { seen = r }))
+	r := httptest.NewRequest("GET", "/", nil)
+	r.RemoteAddr = remote
+	for k, v := range headers {
+		r.Header.Set(k, v)
+	}
+	handler.ServeHTTP(httptest.NewRecorder(), r)
+	return seen
+}
+
+func TestRealIP(t *testing.T) {
+	saved := trustedProxies
+	t.Cleanup(func() { trustedProxies = saved })
+	var err error
+	if trustedProxies, err = parseTrustedProxies("10.0.0.0/8"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Desde un proxy de confianza: el primer salto no confiable desde la derecha
+	r := realIPRequest(t, "10.0.0.5:4000", map[string]string{"X-Forwarded-For": "1.1.1.1, 203.0.113.9, 10.0.0.7"})
+	if r.RemoteAddr != "203.0.113.9" {
+		t.Fatalf("RemoteAddr = %s, se esperaba 203.0.113.9", r.RemoteAddr)
+	}
+
+	// Desde afuera: se ignoran y descartan los headers de reenvío y de autor
+	r = realIPRequest(t, "203.0.113.9:4000", map[string]string{
+		"X-Forwarded-For": "1.1.1.1",
+		"X-Actor-ID":      "admin",
+	})
+	if r.RemoteAddr != "203.0.113.9:4000" {
+		t.Fatalf("RemoteAddr = %s, no debería cambiar", r.RemoteAddr)
+	}
+	if r.Header.Get("X-Forwarded-For") != "" || r.Header.Get("X-Actor-ID") != "" {
+		t.Fatalf("quedaron headers de reenvío: %v", r.Header)
+	}
+
+	// Cadena malformada: se conserva la conexión
+	r = realIPRequest(t, "10.0.0.5:4000", map[string]string{"X-Forwarded-For": "1.1.1.1, basura"})
+	if r.RemoteAddr != "10.0.0.5:4000" {
+		t.Fatalf("RemoteAddr = %s con X-Forwarded-For malformado", r.RemoteAddr)
+	}
+}
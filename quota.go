@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const eventQuotaThreshold = "quota.threshold_crossed"
+
+var (
+	userQuotaBytes  int64 // USER_QUOTA_BYTES, 0 = sin límite
+	quotaThresholds []int // QUOTA_ALERT_THRESHOLDS, porcentajes ascendentes
+	maxUserImages   int64 // MAX_IMAGES_PER_USER, 0 = sin límite
+)
+
+type QuotaThresholdEvent struct {
+	Threshold  int     `json:"threshold"`
+	Percent    float64 `json:"percent"`
+	UsageBytes int64   `json:"usage_bytes"`
+	QuotaBytes int64   `json:"quota_bytes"`
+}
+
+func parseThresholds(v string) ([]int, error) {
+	var thresholds []int
+	for _, p := range strings.Split(v, ",") {
+		p = strings.TrimSpace(p)
+		if p == "" {
+			continue
+		}
+		n, err := strconv.Atoi(p)
+		if err != nil || n <= 0 {
+			return nil, fmt.Errorf("umbral inválido: %q", p)
+		}
+		thresholds = append(thresholds, n)
+	}
+	sort.Ints(thresholds)
+	return thresholds, nil
+}
+
+// userUsage devuelve los bytes ocupados y la cantidad de imágenes no
+// eliminadas
+func userUsage(ctx context.Context, userID string) (int64, int64, error) {
+	var usage, count int64
+	err := db.QueryRowContext(ctx, `SELECT COALESCE(SUM(size_bytes), 0), COUNT(*) FROM images WHERE user_id = ? AND deleted_at IS NULL`,
+		userID).Scan(&usage, &count)
+	return usage, count, err
+}
+
+func exceedsQuota(usage, size int64) bool {
+	return userQuotaBytes > 0 && usage+size > userQuotaBytes
+}
+
+func reachedImageLimit(count int64) bool {
+	return maxUserImages > 0 && count >= maxUserImages
+}
+
+// setImageLimitHeaders informa el límite por cantidad en la respuesta
+func setImageLimitHeaders(w http.ResponseWriter, count int64) {
+	if maxUserImages <= 0 {
+		return
+	}
+	w.Header().Set("X-Image-Limit", strconv.FormatInt(maxUserImages, 10))
+	w.Header().Set("X-Image-Count", strconv.FormatInt(count, 10))
+}
+
+// checkQuotaThresholds informa el uso en headers y emite un evento por cada
+// umbral que se cruzó al pasar de before a after. Debe llamarse antes de
+// escribir el status de la respuesta.
+func checkQuotaThresholds(w http.ResponseWriter, userID string, before, after int64) {
+	if userQuotaBytes <= 0 {
+		return
+	}
+
+	percent := float64(after) * 100 / float64(userQuotaBytes)
+	w.Header().Set("X-Quota-Limit", strconv.FormatInt(userQuotaBytes, 10))
+	w.Header().Set("X-Quota-Used", strconv.FormatInt(after, 10))
+	w.Header().Set("X-Quota-Percent", strconv.FormatFloat(round2(percent), 'f', 2, 64))
+
+	var crossed []string
+	for _, t := range quotaThresholds {
+		limit := userQuotaBytes * int64(t) / 100
+		if before < limit && after >= limit {
+			crossed = append(crossed, strconv.Itoa(t))
+			emitEvent(eventQuotaThreshold, userID, QuotaThresholdEvent{
+				Threshold:  t,
+				Percent:    round2(percent),
+				UsageBytes: after,
+				QuotaBytes: userQuotaBytes,
+			})
+		}
+	}
+	if len(crossed) > 0 {
+		w.Header().Set("X-Quota-Threshold-Crossed", strings.Join(crossed, ","))
+	}
+}
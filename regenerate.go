@@ -0,0 +1,192 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+)
+
+const regenerateBatch = 200
+
+type RegenerateResult struct {
+	ImageID    string   `json:"image_id"`
+	UserID     string   `json:"user_id"`
+	Width      *int     `json:"width,omitempty"`
+	Height     *int     `json:"height,omitempty"`
+	Renditions []string `json:"renditions"`
+	Errors     []string `json:"errors,omitempty"`
+}
+
+// regenerateImageHandler vuelve a procesar una imagen: relee sus
+// dimensiones y rehace las renditions que tenga en cache
+func regenerateImageHandler(w http.ResponseWriter, r *http.Request) {
+	query := `SELECT ` + imageColumns + ` FROM images WHERE id = ? AND deleted_at IS NULL`
+	img, err := scanImage(db.QueryRowContext(r.Context(), query, chi.URLParam(r, "id")))
+	if err == sql.ErrNoRows {
+		respondError(w, http.StatusNotFound, "Imagen no encontrada")
+		return
+	}
+	if err != nil {
+		log.Printf("Error BD: %v", err)
+		respondError(w, http.StatusInternalServerError, "Error consultando BD")
+		return
+	}
+
+	result, err := regenerateImage(r.Context(), img)
+	if err != nil {
+		log.Printf("Error regenerando %s/%s: %v", img.UserID, img.ID, err)
+		respondError(w, http.StatusUnprocessableEntity, "No se pudo procesar la imagen")
+		return
+	}
+	respondJSON(w, http.StatusOK, result)
+}
+
+// regenerateImagesHandler regenera en segundo plano todas las imágenes que
+// cumplen los filtros del listado (más user_id). Sin ningún filtro exige
+// all=true para no reprocesar todo por accidente.
+func regenerateImagesHandler(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	filters, args, err := parseListFilters(q)
+	if err != nil {
+		respondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if userID := q.Get("user_id"); userID != "" {
+		filters += " AND user_id = ?"
+		args = append(args, userID)
+	}
+	if filters == "" && q.Get("all") != "true" {
+		respondError(w, http.StatusBadRequest, "Indicar filtros o all=true")
+		return
+	}
+
+	var matched int
+	err = db.QueryRowContext(r.Context(), `SELECT COUNT(*) FROM images WHERE deleted_at IS NULL`+filters, args...).Scan(&matched)
+	if err != nil {
+		log.Printf("Error BD: %v", err)
+		respondError(w, http.StatusInternalServerError, "Error consultando BD")
+		return
+	}
+
+	go regenerateMatching(filters, args)
+	respondJSON(w, http.StatusAccepted, map[string]int{"matched": matched})
+}
+
+func regenerateMatching(filters string, args []interface{}) {
+	ctx := context.Background()
+	query := `SELECT ` + imageColumns + ` FROM images WHERE deleted_at IS NULL` + filters + ` AND id > ? ORDER BY id LIMIT ?`
+
+	done, failed := 0, 0
+	lastID := ""
+	for {
+		rows, err := db.QueryContext(ctx, query, append(args, lastID, regenerateBatch)...)
+		if err != nil {
+			log.Printf("Error BD regenerando imágenes: %v", err)
+			return
+		}
+		var batch []Image
+		for rows.Next() {
+			if img, err := scanImage(rows); err == nil {
+				batch = append(batch, img)
+			}
+		}
+		rows.Close()
+		if len(batch) == 0 {
+			break
+		}
+
+		for _, img := range batch {
+			lastID = img.ID
+			result, err := regenerateImage(ctx, img)
+			if err != nil || len(result.Errors) > 0 {
+				log.Printf("✗ Regeneración de %s/%s: %v %v", img.UserID, img.ID, err, result.Errors)
+				failed++
+				continue
+			}
+			done++
+		}
+	}
+	log.Printf("✅ Regeneración terminada: %d imágenes, %d con errores", done, failed)
+}
+
+func regenerateImage(ctx context.Context, img Image) (RegenerateResult, error) {
+	result := RegenerateResult{ImageID: img.ID, UserID: img.UserID, Renditions: make([]string, 0)}
+
+	width, height, err := storedImageDimensions(ctx, img)
+	if err != nil {
+		return result, err
+	}
+	if img.Width == nil || img.Height == nil || *img.Width != width || *img.Height != height {
+		if err := updateDimensions(ctx, img, width, height); err != nil {
+			return result, err
+		}
+	}
+	img.Width, img.Height = &width, &height
+	result.Width, result.Height = &width, &height
+
+	// Rehacer cada rendition cacheada con la lógica actual. Las que ya no
+	// se pueden interpretar se descartan y se generarán al pedirse.
+	dir := renditionDir(img)
+	entries, err := os.ReadDir(dir)
+	if err != nil && !os.IsNotExist(err) {
+		return result, err
+	}
+	for _, entry := range entries {
+		if strings.HasSuffix(entry.Name(), partialSuffix) {
+			continue // rendition generándose en este momento
+		}
+		path := filepath.Join(dir, entry.Name())
+		if err := os.Remove(path); err != nil {
+			result.Errors = append(result.Errors, fmt.Sprintf("%s: %v", entry.Name(), err))
+			continue
+		}
+		p, ok := parseRenditionKey(entry.Name())
+		if !ok {
+			continue
+		}
+		if _, err := renderTransform(ctx, img, p); err != nil {
+			result.Errors = append(result.Errors, fmt.Sprintf("%s: %v", p.key(), err))
+			continue
+		}
+		result.Renditions = append(result.Renditions, p.key())
+	}
+	return result, nil
+}
+
+func updateDimensions(ctx context.Context, img Image, width, height int) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, `UPDATE images SET width = ?, height = ? WHERE id = ?`, width, height, img.ID); err != nil {
+		return err
+	}
+	if err := recordChange(ctx, tx, img.UserID, img.ID, changeUpdated); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// parseRenditionKey es la inversa de transformParams.key() más la extensión
+func parseRenditionKey(name string) (transformParams, bool) {
+	var p transformParams
+	key := strings.TrimSuffix(name, filepath.Ext(name))
+	size, fit, ok := strings.Cut(key, "-")
+	if !ok {
+		return p, false
+	}
+	if _, err := fmt.Sscanf(size, "%dx%d", &p.Width, &p.Height); err != nil {
+		return p, false
+	}
+	p.Fit = fit
+	return p, p.key() == key
+}
@@ -0,0 +1,397 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+)
+
+const (
+	relocationRunning = "running"
+	relocationDone    = "done"
+	relocationFailed  = "failed"
+
+	relocationBatch = 200
+)
+
+// StorageMigration copia todos los archivos de un backend a otro (entre
+// volúmenes o de un volumen a S3). El avance queda en storage_migrations y
+// cada imagen se apunta al backend destino recién después de verificar la
+// copia, así que reanudar solo procesa lo que quedó.
+type StorageMigration struct {
+	ID        string    `json:"id"`
+	Source    string    `json:"source"`
+	Target    string    `json:"target"`
+	Status    string    `json:"status"`
+	Copied    int       `json:"copied"`
+	Failed    int       `json:"failed"`
+	Bytes     int64     `json:"bytes"`
+	Error     string    `json:"error,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+type storageMigrationRequest struct {
+	Source string `json:"source"`
+	Target string `json:"target"`
+}
+
+// storageMigrationLockPrefix + id es el lock con nombre de MySQL que
+// reclama una migración: una sola réplica la procesa a la vez
+const storageMigrationLockPrefix = "image_api_relocation:"
+
+func createStorageMigrationHandler(w http.ResponseWriter, r *http.Request) {
+	var req storageMigrationRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondError(w, http.StatusBadRequest, "JSON inválido")
+		return
+	}
+	if _, ok := findBackend(req.Source); !ok {
+		respondError(w, http.StatusBadRequest, "Backend de origen desconocido: "+req.Source)
+		return
+	}
+	if _, ok := findBackend(req.Target); !ok {
+		respondError(w, http.StatusBadRequest, "Backend de destino desconocido: "+req.Target)
+		return
+	}
+	if req.Source == req.Target {
+		respondError(w, http.StatusBadRequest, "Origen y destino deben ser distintos")
+		return
+	}
+
+	var running int
+	err := db.QueryRowContext(r.Context(), `SELECT COUNT(*) FROM storage_migrations WHERE status = ? AND (source IN (?, ?) OR target IN (?, ?))`,
+		relocationRunning, req.Source, req.Target, req.Source, req.Target).Scan(&running)
+	if err != nil {
+		log.Printf("Error BD: %v", err)
+		respondError(w, http.StatusInternalServerError, "Error consultando BD")
+		return
+	}
+	if running > 0 {
+		respondError(w, http.StatusConflict, "Ya hay una migración en curso sobre esos backends")
+		return
+	}
+
+	id := uuid.New().String()
+	if _, err := db.ExecContext(r.Context(), `INSERT INTO storage_migrations (id, source, target, status) VALUES (?, ?, ?, ?)`,
+		id, req.Source, req.Target, relocationRunning); err != nil {
+		log.Printf("Error BD: %v", err)
+		respondError(w, http.StatusInternalServerError, "Error guardando migración")
+		return
+	}
+
+	m, err := findStorageMigration(r.Context(), id)
+	if err != nil {
+		log.Printf("Error BD: %v", err)
+		respondError(w, http.StatusInternalServerError, "Error consultando BD")
+		return
+	}
+	go runStorageMigration(m)
+	respondJSON(w, http.StatusAccepted, m)
+}
+
+func listStorageMigrationsHandler(w http.ResponseWriter, r *http.Request) {
+	rows, err := db.QueryContext(r.Context(), `SELECT `+storageMigrationColumns+` FROM storage_migrations ORDER BY created_at DESC`)
+	if err != nil {
+		log.Printf("Error BD: %v", err)
+		respondError(w, http.StatusInternalServerError, "Error consultando BD")
+		return
+	}
+	defer rows.Close()
+
+	list := make([]StorageMigration, 0)
+	for rows.Next() {
+		m, err := scanStorageMigration(rows)
+		if err != nil {
+			log.Printf("Error escaneando fila: %v", err)
+			continue
+		}
+		list = append(list, m)
+	}
+	respondJSON(w, http.StatusOK, list)
+}
+
+func getStorageMigrationHandler(w http.ResponseWriter, r *http.Request) {
+	m, err := findStorageMigration(r.Context(), chi.URLParam(r, "id"))
+	if err == sql.ErrNoRows {
+		respondError(w, http.StatusNotFound, "Migración no encontrada")
+		return
+	}
+	if err != nil {
+		log.Printf("Error BD: %v", err)
+		respondError(w, http.StatusInternalServerError, "Error consultando BD")
+		return
+	}
+	respondJSON(w, http.StatusOK, m)
+}
+
+// resumeStorageMigrationHandler vuelve a lanzar una migración fallida o
+// terminada con errores; solo se procesan las imágenes que siguen en origen
+func resumeStorageMigrationHandler(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	res, err := db.ExecContext(r.Context(), `UPDATE storage_migrations SET status = ?, error = NULL WHERE id = ?`, relocationRunning, id)
+	if err != nil {
+		log.Printf("Error BD: %v", err)
+		respondError(w, http.StatusInternalServerError, "Error actualizando migración")
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		if _, err := findStorageMigration(r.Context(), id); err == sql.ErrNoRows {
+			respondError(w, http.StatusNotFound, "Migración no encontrada")
+			return
+		}
+	}
+
+	m, err := findStorageMigration(r.Context(), id)
+	if err != nil {
+		log.Printf("Error BD: %v", err)
+		respondError(w, http.StatusInternalServerError, "Error consultando BD")
+		return
+	}
+	go runStorageMigration(m)
+	respondJSON(w, http.StatusAccepted, m)
+}
+
+// resumeStorageMigrations retoma al arrancar las migraciones que quedaron
+// en curso cuando se detuvo el proceso
+func resumeStorageMigrations() {
+	rows, err := db.Query(`SELECT `+storageMigrationColumns+` FROM storage_migrations WHERE status = ?`, relocationRunning)
+	if err != nil {
+		log.Printf("Error BD buscando migraciones pendientes: %v", err)
+		return
+	}
+	var pending []StorageMigration
+	for rows.Next() {
+		if m, err := scanStorageMigration(rows); err == nil {
+			pending = append(pending, m)
+		}
+	}
+	rows.Close()
+
+	for _, m := range pending {
+		log.Printf("→ Reanudando migración de almacenamiento %s (%s → %s)", m.ID, m.Source, m.Target)
+		go runStorageMigration(m)
+	}
+}
+
+func runStorageMigration(m StorageMigration) {
+	ctx := context.Background()
+	conn, claimed, err := claimStorageMigration(ctx, m.ID)
+	if err != nil {
+		log.Printf("Error BD reclamando migración %s: %v", m.ID, err)
+		return
+	}
+	if !claimed {
+		log.Printf("→ Migración %s ya en curso en otra réplica", m.ID)
+		return
+	}
+	defer releaseStorageMigration(conn, m.ID)
+
+	source, okSrc := findBackend(m.Source)
+	target, okDst := findBackend(m.Target)
+	if !okSrc || !okDst {
+		finishStorageMigration(m.ID, relocationFailed, "backend no configurado en esta réplica")
+		return
+	}
+
+	// Imágenes previas a la columna volume se reconocen por la ruta
+	where := `volume = ?`
+	args := []interface{}{source.Name()}
+	prefix := ""
+	if local, ok := source.(*localBackend); ok {
+		prefix = filepath.Clean(local.volume.Path) + string(filepath.Separator)
+		where = `(volume = ? OR (volume IS NULL AND file_path LIKE ?))`
+		args = append(args, prefix+"%")
+	}
+	query := `SELECT ` + imageColumns + ` FROM images WHERE ` + where + ` AND id > ? ORDER BY id LIMIT ?`
+
+	lastID := ""
+	for {
+		rows, err := db.QueryContext(ctx, query, append(args, lastID, relocationBatch)...)
+		if err != nil {
+			finishStorageMigration(m.ID, relocationFailed, err.Error())
+			return
+		}
+		batch := make([]Image, 0, relocationBatch)
+		for rows.Next() {
+			img, err := scanImage(rows)
+			if err != nil {
+				log.Printf("Error escaneando fila: %v", err)
+				continue
+			}
+			batch = append(batch, img)
+		}
+		rows.Close()
+		if len(batch) == 0 {
+			break
+		}
+
+		for _, img := range batch {
+			lastID = img.ID
+			if img.Volume == "" && !strings.HasPrefix(img.FilePath, prefix) {
+				continue
+			}
+			// Si se cortó la conexión del lock otra réplica pudo retomar la
+			// migración: no seguir copiando en paralelo
+			if !holdsStorageMigration(ctx, conn, m.ID) {
+				log.Printf("⚠️  Migración %s: se perdió el lock, se deja de procesar", m.ID)
+				return
+			}
+
+			copied, failed := 0, 0
+			if err := relocateImage(ctx, img, source, target); err != nil {
+				log.Printf("✗ Migración %s: %s/%s: %v", m.ID, img.UserID, img.ID, err)
+				failed = 1
+			} else {
+				copied = 1
+			}
+			_, err := db.ExecContext(ctx, `UPDATE storage_migrations SET copied = copied + ?, failed = failed + ?, bytes = bytes + ? WHERE id = ?`,
+				copied, failed, int64(copied)*img.SizeBytes, m.ID)
+			if err != nil {
+				log.Printf("Error BD actualizando migración %s: %v", m.ID, err)
+			}
+		}
+	}
+
+	finishStorageMigration(m.ID, relocationDone, "")
+	log.Printf("✅ Migración de almacenamiento %s terminada (%s → %s)", m.ID, m.Source, m.Target)
+}
+
+// claimStorageMigration toma el lock de la migración sin esperar. El lock
+// vive en conn: si la réplica muere, MySQL lo libera al cortarse la
+// conexión y otra puede retomarla.
+func claimStorageMigration(ctx context.Context, id string) (*sql.Conn, bool, error) {
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return nil, false, err
+	}
+	var locked sql.NullInt64
+	if err := conn.QueryRowContext(ctx, `SELECT GET_LOCK(?, 0)`, storageMigrationLockPrefix+id).Scan(&locked); err != nil {
+		conn.Close()
+		return nil, false, err
+	}
+	if locked.Int64 != 1 {
+		conn.Close()
+		return nil, false, nil
+	}
+	return conn, true, nil
+}
+
+func holdsStorageMigration(ctx context.Context, conn *sql.Conn, id string) bool {
+	var held sql.NullBool
+	err := conn.QueryRowContext(ctx, `SELECT IS_USED_LOCK(?) = CONNECTION_ID()`, storageMigrationLockPrefix+id).Scan(&held)
+	return err == nil && held.Bool
+}
+
+func releaseStorageMigration(conn *sql.Conn, id string) {
+	if _, err := conn.ExecContext(context.Background(), `SELECT RELEASE_LOCK(?)`, storageMigrationLockPrefix+id); err != nil {
+		log.Printf("Error BD liberando migración %s: %v", id, err)
+	}
+	conn.Close()
+}
+
+// relocateImage copia el original al backend destino, relee la copia para
+// verificar el checksum contra el registrado y recién entonces apunta el
+// registro a la copia
+func relocateImage(ctx context.Context, img Image, source, target Backend) error {
+	key := target.Key(img.UserID, path.Base(filepath.ToSlash(img.FilePath)))
+
+	src, err := source.Get(ctx, img.FilePath)
+	if err != nil {
+		return err
+	}
+	_, readSum, err := target.Put(ctx, key, src)
+	src.Close()
+	if err != nil {
+		return err
+	}
+
+	// readSum es lo leído del origen; releer la copia confirma lo escrito
+	writtenSum, err := blobChecksum(ctx, target, key)
+	if err == nil && writtenSum != readSum {
+		err = fmt.Errorf("checksum de la copia no coincide")
+	}
+	if err == nil && img.Checksum != "" && img.Checksum != readSum {
+		err = fmt.Errorf("checksum del origen no coincide con el registrado")
+	}
+	if err != nil {
+		discardCopy(ctx, img.ID, target, key)
+		return err
+	}
+
+	res, err := db.ExecContext(ctx, `UPDATE images SET file_path = ?, volume = ?, checksum = ? WHERE id = ? AND file_path = ?`,
+		key, target.Name(), readSum, img.ID, img.FilePath)
+	if err != nil {
+		discardCopy(ctx, img.ID, target, key)
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		discardCopy(ctx, img.ID, target, key)
+		return fmt.Errorf("el registro cambió durante la copia")
+	}
+
+	// Las renditions se regeneran junto al nuevo original
+	if err := purgeRenditions(ctx, img); err != nil {
+		log.Printf("Error BD eliminando renditions de %s: %v", img.ID, err)
+	}
+	if err := source.Delete(ctx, img.FilePath); err != nil {
+		log.Printf("No se pudo eliminar el original %s: %v", img.FilePath, err)
+	}
+	return nil
+}
+
+// discardCopy borra una copia que no se llegó a registrar, salvo que el
+// registro ya apunte a ella: nunca se borra un archivo en uso
+func discardCopy(ctx context.Context, imageID string, target Backend, key string) {
+	var inUse bool
+	err := db.QueryRowContext(ctx, `SELECT EXISTS(SELECT 1 FROM images WHERE id = ? AND volume = ? AND file_path = ?)`,
+		imageID, target.Name(), key).Scan(&inUse)
+	if err != nil || inUse {
+		return
+	}
+	if err := target.Delete(ctx, key); err != nil {
+		log.Printf("No se pudo eliminar la copia %s: %v", key, err)
+	}
+}
+
+func blobChecksum(ctx context.Context, b Backend, key string) (string, error) {
+	rc, err := b.Get(ctx, key)
+	if err != nil {
+		return "", err
+	}
+	defer rc.Close()
+	return readChecksum(rc)
+}
+
+func finishStorageMigration(id, status, errMsg string) {
+	var errVal interface{}
+	if errMsg != "" {
+		errVal = errMsg
+		log.Printf("⚠️  Migración de almacenamiento %s: %s", id, errMsg)
+	}
+	if _, err := db.Exec(`UPDATE storage_migrations SET status = ?, error = ? WHERE id = ?`, status, errVal, id); err != nil {
+		log.Printf("Error BD cerrando migración %s: %v", id, err)
+	}
+}
+
+const storageMigrationColumns = `id, source, target, status, copied, failed, bytes, COALESCE(error, ''), created_at, updated_at`
+
+func scanStorageMigration(row rowScanner) (StorageMigration, error) {
+	var m StorageMigration
+	err := row.Scan(&m.ID, &m.Source, &m.Target, &m.Status, &m.Copied, &m.Failed, &m.Bytes, &m.Error, &m.CreatedAt, &m.UpdatedAt)
+	return m, err
+}
+
+func findStorageMigration(ctx context.Context, id string) (StorageMigration, error) {
+	return scanStorageMigration(db.QueryRowContext(ctx, `SELECT `+storageMigrationColumns+` FROM storage_migrations WHERE id = ?`, id))
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"log"
+	"os"
+)
+
+// trackRendition registra una rendition generada para poder encontrarla y
+// borrarla junto con su original
+func trackRendition(imageID, key, path string, size int64) {
+	query := `INSERT INTO renditions (image_id, rendition_key, file_path, size_bytes) VALUES (?, ?, ?, ?)
+			  ON DUPLICATE KEY UPDATE file_path = VALUES(file_path), size_bytes = VALUES(size_bytes), created_at = NOW()`
+	if _, err := db.Exec(query, imageID, key, path, size); err != nil {
+		log.Printf("Error BD registrando rendition %s/%s: %v", imageID, key, err)
+	}
+}
+
+// purgeRenditions elimina todas las renditions de una imagen: las
+// registradas, estén donde estén, y su directorio de cache, que cubre las
+// generadas antes de que existiera el registro
+func purgeRenditions(ctx context.Context, img Image) error {
+	rows, err := db.QueryContext(ctx, `SELECT file_path FROM renditions WHERE image_id = ?`, img.ID)
+	if err != nil {
+		return err
+	}
+	var paths []string
+	for rows.Next() {
+		var path string
+		if err := rows.Scan(&path); err == nil {
+			paths = append(paths, path)
+		}
+	}
+	rows.Close()
+
+	for _, path := range paths {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			log.Printf("Error eliminando rendition %s: %v", path, err)
+		}
+	}
+	if err := os.RemoveAll(renditionDir(img)); err != nil {
+		log.Printf("Error eliminando renditions de %s: %v", img.ID, err)
+	}
+
+	_, err = db.ExecContext(ctx, `DELETE FROM renditions WHERE image_id = ?`, img.ID)
+	return err
+}
+
+// hardDeleteImage borra definitivamente la imagen, todo lo que la
+// referencia y sus archivos. Los registros se borran primero: si después
+// falla algún archivo queda como huérfano para -check. Solo queda una
+// lápida en purged_images con lo que necesita el reporte de uso.
+func hardDeleteImage(ctx context.Context, img Image) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, query := range []string{
+		`INSERT INTO purged_images (image_id, user_id, size_bytes, created_at, deleted_at)
+		 SELECT id, user_id, size_bytes, created_at, COALESCE(deleted_at, NOW()) FROM images WHERE id = ?`,
+		`DELETE FROM share_links WHERE image_id = ?`,
+		`DELETE FROM image_slots WHERE image_id = ?`,
+		`DELETE FROM image_tags WHERE image_id = ?`,
+		`DELETE FROM images WHERE id = ?`,
+	} {
+		if _, err := tx.ExecContext(ctx, query, img.ID); err != nil {
+			return err
+		}
+	}
+	if err := recordChange(ctx, tx, img.UserID, img.ID, changeDeleted); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	if err := purgeRenditions(ctx, img); err != nil {
+		log.Printf("Error BD eliminando renditions de %s: %v", img.ID, err)
+	}
+	b, err := backendFor(img)
+	if err == nil {
+		err = b.Delete(ctx, img.FilePath)
+	}
+	if err != nil {
+		log.Printf("Error eliminando %s: %v", img.FilePath, err)
+	}
+	return nil
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const usageFlushInterval = time.Minute
+
+// bandwidth acumula en memoria los bytes servidos por usuario hasta el
+// próximo flush, para no escribir en BD en cada descarga
+var bandwidth = struct {
+	sync.Mutex
+	byUser map[string]*bandwidthCounter
+}{byUser: make(map[string]*bandwidthCounter)}
+
+type bandwidthCounter struct {
+	bytes    int64
+	requests int64
+}
+
+type UsageRow struct {
+	UserID         string `json:"user_id"`
+	Uploads        int64  `json:"uploads"`
+	Deletions      int64  `json:"deletions"`
+	BytesAdded     int64  `json:"bytes_added"`
+	BandwidthBytes int64  `json:"bandwidth_bytes"`
+	Downloads      int64  `json:"downloads"`
+}
+
+type UsageReport struct {
+	Month       string     `json:"month"`
+	GeneratedAt time.Time  `json:"generated_at"`
+	Global      UsageRow   `json:"global"`
+	Users       []UsageRow `json:"users"`
+}
+
+func recordBandwidth(userID string, n int64) {
+	bandwidth.Lock()
+	defer bandwidth.Unlock()
+	c, ok := bandwidth.byUser[userID]
+	if !ok {
+		c = &bandwidthCounter{}
+		bandwidth.byUser[userID] = c
+	}
+	c.bytes += n
+	c.requests++
+}
+
+func flushUsageLoop() {
+	ticker := time.NewTicker(usageFlushInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		workerBeat("usage_flush")
+		flushUsage()
+	}
+}
+
+// flushUsage vuelca los contadores al día actual (UTC)
+func flushUsage() {
+	bandwidth.Lock()
+	pending := bandwidth.byUser
+	bandwidth.byUser = make(map[string]*bandwidthCounter)
+	bandwidth.Unlock()
+
+	day := time.Now().UTC().Format("2006-01-02")
+	query := `INSERT INTO usage_daily (user_id, day, bytes_served, requests) VALUES (?, ?, ?, ?)
+			  ON DUPLICATE KEY UPDATE bytes_served = bytes_served + VALUES(bytes_served), requests = requests + VALUES(requests)`
+	for userID, c := range pending {
+		if _, err := db.Exec(query, userID, day, c.bytes, c.requests); err != nil {
+			log.Printf("Error BD guardando uso de %s: %v", userID, err)
+		}
+	}
+}
+
+// usageReportHandler genera el resumen mensual (?month=YYYY-MM, por
+// defecto el mes anterior) en JSON o CSV (?format=csv)
+func usageReportHandler(w http.ResponseWriter, r *http.Request) {
+	var start time.Time
+	if m := r.URL.Query().Get("month"); m != "" {
+		var err error
+		start, err = time.Parse("2006-01", m)
+		if err != nil {
+			respondError(w, http.StatusBadRequest, "month debe tener formato YYYY-MM")
+			return
+		}
+	} else {
+		now := time.Now().UTC()
+		start = time.Date(now.Year(), now.Month()-1, 1, 0, 0, 0, 0, time.UTC)
+	}
+
+	report, err := buildUsageReport(r.Context(), start, r.URL.Query().Get("user_id"))
+	if err != nil {
+		log.Printf("Error BD: %v", err)
+		respondError(w, http.StatusInternalServerError, "Error generando reporte")
+		return
+	}
+
+	if r.URL.Query().Get("format") == "csv" {
+		writeUsageCSV(w, report)
+		return
+	}
+	respondJSON(w, http.StatusOK, report)
+}
+
+func buildUsageReport(ctx context.Context, start time.Time, userID string) (UsageReport, error) {
+	end := start.AddDate(0, 1, 0)
+	rows := make(map[string]*UsageRow)
+	row := func(id string) *UsageRow {
+		if rows[id] == nil {
+			rows[id] = &UsageRow{UserID: id}
+		}
+		return rows[id]
+	}
+
+	filter := ""
+	args := []interface{}{start, end}
+	if userID != "" {
+		filter = " AND user_id = ?"
+		args = append(args, userID)
+	}
+
+	// Las imágenes borradas definitivamente siguen contando por su lápida
+	const allImages = `(SELECT user_id, size_bytes, created_at, deleted_at FROM images
+		UNION ALL SELECT user_id, size_bytes, created_at, deleted_at FROM purged_images) AS i`
+
+	// Cada consulta devuelve user_id y dos contadores
+	queries := []struct {
+		query string
+		apply func(r *UsageRow, a, b int64)
+	}{
+		{`SELECT user_id, COUNT(*), COALESCE(SUM(size_bytes), 0) FROM ` + allImages + `
+		  WHERE created_at >= ? AND created_at < ?` + filter + ` GROUP BY user_id`,
+			func(r *UsageRow, a, b int64) { r.Uploads, r.BytesAdded = a, b }},
+		{`SELECT user_id, COUNT(*), 0 FROM ` + allImages + `
+		  WHERE deleted_at >= ? AND deleted_at < ?` + filter + ` GROUP BY user_id`,
+			func(r *UsageRow, a, _ int64) { r.Deletions = a }},
+		{`SELECT user_id, SUM(bytes_served), SUM(requests) FROM usage_daily
+		  WHERE day >= ? AND day < ?` + filter + ` GROUP BY user_id`,
+			func(r *UsageRow, a, b int64) { r.BandwidthBytes, r.Downloads = a, b }},
+	}
+
+	for _, q := range queries {
+		res, err := db.QueryContext(ctx, q.query, args...)
+		if err != nil {
+			return UsageReport{}, err
+		}
+		for res.Next() {
+			var id string
+			var a, b int64
+			if err := res.Scan(&id, &a, &b); err != nil {
+				res.Close()
+				return UsageReport{}, err
+			}
+			q.apply(row(id), a, b)
+		}
+		res.Close()
+		if err := res.Err(); err != nil {
+			return UsageReport{}, err
+		}
+	}
+
+	report := UsageReport{
+		Month:       start.Format("2006-01"),
+		GeneratedAt: time.Now().UTC(),
+		Global:      UsageRow{UserID: "*"},
+		Users:       make([]UsageRow, 0, len(rows)),
+	}
+	for _, r := range rows {
+		report.Users = append(report.Users, *r)
+		report.Global.Uploads += r.Uploads
+		report.Global.Deletions += r.Deletions
+		report.Global.BytesAdded += r.BytesAdded
+		report.Global.BandwidthBytes += r.BandwidthBytes
+		report.Global.Downloads += r.Downloads
+	}
+	sort.Slice(report.Users, func(i, j int) bool { return report.Users[i].UserID < report.Users[j].UserID })
+	return report, nil
+}
+
+// writeUsageCSV escribe una fila por usuario y una final con el total global
+func writeUsageCSV(w http.ResponseWriter, report UsageReport) {
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	w.Header().Set("Content-Disposition", `attachment; filename="usage-`+report.Month+`.csv"`)
+
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"month", "user_id", "uploads", "deletions", "bytes_added", "bandwidth_bytes", "downloads"})
+	for _, r := range append(report.Users, report.Global) {
+		cw.Write([]string{
+			report.Month,
+			r.UserID,
+			strconv.FormatInt(r.Uploads, 10),
+			strconv.FormatInt(r.Deletions, 10),
+			strconv.FormatInt(r.BytesAdded, 10),
+			strconv.FormatInt(r.BandwidthBytes, 10),
+			strconv.FormatInt(r.Downloads, 10),
+		})
+	}
+	cw.Flush()
+}
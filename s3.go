@@ -0,0 +1,219 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+	"time"
+)
+
+// s3HeaderTimeout acota la espera de la respuesta; el body no tiene límite
+// propio porque una descarga lenta puede durar lo que permita la petición
+const s3HeaderTimeout = 30 * time.Second
+
+// s3Backend guarda los originales en un bucket de S3 (o compatible, como
+// MinIO) con URLs path-style y firma SigV4, sin depender del SDK
+type s3Backend struct {
+	name         string
+	endpoint     string // sin barra final, ej. https://s3.us-east-1.amazonaws.com
+	bucket       string
+	prefix       string
+	region       string
+	accessKey    string
+	secretKey    string
+	sessionToken string
+	client       *http.Client
+}
+
+// s3BackendFromEnv lee S3_BUCKET y compañía; sin bucket no hay backend S3.
+// Las credenciales son las estándar de AWS.
+func s3BackendFromEnv() (*s3Backend, error) {
+	bucket := os.Getenv("S3_BUCKET")
+	if bucket == "" {
+		return nil, nil
+	}
+	b := &s3Backend{
+		name:         getEnv("S3_BACKEND_NAME", "s3"),
+		bucket:       bucket,
+		prefix:       strings.Trim(os.Getenv("S3_PREFIX"), "/"),
+		region:       getEnv("S3_REGION", "us-east-1"),
+		accessKey:    os.Getenv("AWS_ACCESS_KEY_ID"),
+		secretKey:    os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		sessionToken: os.Getenv("AWS_SESSION_TOKEN"),
+		client:       &http.Client{Transport: s3Transport()},
+	}
+	b.endpoint = strings.TrimRight(getEnv("S3_ENDPOINT", "https://s3."+b.region+".amazonaws.com"), "/")
+	if b.accessKey == "" || b.secretKey == "" {
+		return nil, fmt.Errorf("S3_BUCKET requiere AWS_ACCESS_KEY_ID y AWS_SECRET_ACCESS_KEY")
+	}
+	return b, nil
+}
+
+func s3Transport() http.RoundTripper {
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	t.ResponseHeaderTimeout = s3HeaderTimeout
+	return t
+}
+
+func (b *s3Backend) Name() string { return b.name }
+
+func (b *s3Backend) Key(userID, filename string) string {
+	return path.Join(b.prefix, userID, filename)
+}
+
+// Put espera el archivo en un temporal: S3 exige Content-Length y el
+// sha256 del body para firmar, que además es el checksum que se devuelve
+func (b *s3Backend) Put(ctx context.Context, key string, src io.Reader) (int64, string, error) {
+	tmp, err := os.CreateTemp("", "s3-put-*")
+	if err != nil {
+		return 0, "", err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	hash := sha256.New()
+	size, err := io.Copy(io.MultiWriter(tmp, hash), ctxReader{ctx, src})
+	if err != nil {
+		return 0, "", err
+	}
+	if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+		return 0, "", err
+	}
+	sum := hex.EncodeToString(hash.Sum(nil))
+
+	req, err := b.request(ctx, http.MethodPut, key, tmp, sum)
+	if err != nil {
+		return 0, "", err
+	}
+	req.ContentLength = size
+	resp, err := b.client.Do(req)
+	if err != nil {
+		return 0, "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return 0, "", s3Error(resp)
+	}
+	return size, sum, nil
+}
+
+func (b *s3Backend) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	resp, err := b.do(ctx, http.MethodGet, key)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		return nil, s3Error(resp)
+	}
+	return resp.Body, nil
+}
+
+func (b *s3Backend) Stat(ctx context.Context, key string) (BlobInfo, error) {
+	resp, err := b.do(ctx, http.MethodHead, key)
+	if err != nil {
+		return BlobInfo{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return BlobInfo{}, s3Error(resp)
+	}
+	return BlobInfo{Size: resp.ContentLength}, nil
+}
+
+func (b *s3Backend) Delete(ctx context.Context, key string) error {
+	resp, err := b.do(ctx, http.MethodDelete, key)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNotFound {
+		return s3Error(resp)
+	}
+	return nil
+}
+
+// emptyPayloadHash es el sha256 de un body vacío
+const emptyPayloadHash = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+func (b *s3Backend) do(ctx context.Context, method, key string) (*http.Response, error) {
+	req, err := b.request(ctx, method, key, nil, emptyPayloadHash)
+	if err != nil {
+		return nil, err
+	}
+	return b.client.Do(req)
+}
+
+func (b *s3Backend) request(ctx context.Context, method, key string, body io.Reader, payloadHash string) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, method, b.endpoint+"/"+s3Escape(b.bucket)+"/"+s3Escape(key), body)
+	if err != nil {
+		return nil, err
+	}
+	b.sign(req, payloadHash, time.Now().UTC())
+	return req, nil
+}
+
+// sign agrega la firma AWS Signature Version 4 con los headers mínimos:
+// host, x-amz-content-sha256, x-amz-date y, si hay, el token de sesión
+func (b *s3Backend) sign(req *http.Request, payloadHash string, now time.Time) {
+	amzDate := now.Format("20060102T150405Z")
+	day := now.Format("20060102")
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	headers := "host:" + req.URL.Host + "\nx-amz-content-sha256:" + payloadHash + "\nx-amz-date:" + amzDate + "\n"
+	signed := "host;x-amz-content-sha256;x-amz-date"
+	if b.sessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", b.sessionToken)
+		headers += "x-amz-security-token:" + b.sessionToken + "\n"
+		signed += ";x-amz-security-token"
+	}
+
+	canonical := strings.Join([]string{req.Method, req.URL.EscapedPath(), req.URL.RawQuery, headers, signed, payloadHash}, "\n")
+	scope := day + "/" + b.region + "/s3/aws4_request"
+	hashed := sha256.Sum256([]byte(canonical))
+	toSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(hashed[:])
+
+	key := []byte("AWS4" + b.secretKey)
+	for _, part := range []string{day, b.region, "s3", "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		b.accessKey, scope, signed, hex.EncodeToString(hmacSHA256(key, toSign))))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// s3Escape codifica todo salvo los caracteres no reservados y "/", como
+// exige la ruta canónica de SigV4
+func s3Escape(key string) string {
+	var sb strings.Builder
+	for i := 0; i < len(key); i++ {
+		c := key[i]
+		if c >= 'A' && c <= 'Z' || c >= 'a' && c <= 'z' || c >= '0' && c <= '9' || strings.IndexByte("-._~/", c) >= 0 {
+			sb.WriteByte(c)
+		} else {
+			fmt.Fprintf(&sb, "%%%02X", c)
+		}
+	}
+	return sb.String()
+}
+
+func s3Error(resp *http.Response) error {
+	if resp.StatusCode == http.StatusNotFound {
+		return errBlobNotFound
+	}
+	msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+	return fmt.Errorf("S3 respondió %d: %s", resp.StatusCode, strings.TrimSpace(string(msg)))
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	qrcode "github.com/skip2/go-qrcode"
+)
+
+const (
+	shareTokenBytes = 16
+	qrDefaultSize   = 256
+	qrMinSize       = 128
+	qrMaxSize       = 1024
+)
+
+type ShareLink struct {
+	Token      string     `json:"token"`
+	ImageID    string     `json:"image_id"`
+	UserID     string     `json:"user_id"`
+	URL        string     `json:"url"`
+	QRURL      string     `json:"qr_url"`
+	PreviewURL string     `json:"preview_url"`
+	QRCode     string     `json:"qr_code,omitempty"` // PNG como data URI
+	CreatedAt  time.Time  `json:"created_at"`
+	ExpiresAt  *time.Time `json:"expires_at,omitempty"`
+}
+
+type shareRequest struct {
+	ExpiresIn int  `json:"expires_in"` // segundos, 0 = sin expiración
+	QR        bool `json:"qr"`         // incluir el QR en la respuesta
+}
+
+func createShareHandler(w http.ResponseWriter, r *http.Request) {
+	userID := chi.URLParam(r, "userId")
+	imageID := chi.URLParam(r, "id")
+
+	// El body es opcional
+	var req shareRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+		respondError(w, http.StatusBadRequest, "JSON inválido")
+		return
+	}
+	if req.ExpiresIn < 0 {
+		respondError(w, http.StatusBadRequest, "expires_in no puede ser negativo")
+		return
+	}
+
+	if _, err := findImage(r.Context(), userID, imageID); err == sql.ErrNoRows {
+		respondError(w, http.StatusNotFound, "Imagen no encontrada")
+		return
+	} else if err != nil {
+		log.Printf("Error BD: %v", err)
+		respondError(w, http.StatusInternalServerError, "Error consultando BD")
+		return
+	}
+
+	token, err := newShareToken()
+	if err != nil {
+		log.Printf("Error generando token: %v", err)
+		respondError(w, http.StatusInternalServerError, "Error generando enlace")
+		return
+	}
+
+	link := ShareLink{
+		Token:     token,
+		ImageID:   imageID,
+		UserID:    userID,
+		CreatedAt: time.Now().UTC(),
+	}
+	if req.ExpiresIn > 0 {
+		expires := link.CreatedAt.Add(time.Duration(req.ExpiresIn) * time.Second)
+		link.ExpiresAt = &expires
+	}
+
+	query := `INSERT INTO share_links (token, image_id, user_id, created_at, expires_at) VALUES (?, ?, ?, ?, ?)`
+	if _, err := db.ExecContext(r.Context(), query, link.Token, link.ImageID, link.UserID, link.CreatedAt, link.ExpiresAt); err != nil {
+		log.Printf("Error BD: %v", err)
+		respondError(w, http.StatusInternalServerError, "Error guardando enlace")
+		return
+	}
+
+	link.URL = shareURL(r, token)
+	link.QRURL = link.URL + "/qr.png"
+	link.PreviewURL = previewURL(r, token)
+	if req.QR || r.URL.Query().Get("qr") == "true" {
+		png, err := qrcode.Encode(link.URL, qrcode.Medium, qrDefaultSize)
+		if err != nil {
+			log.Printf("Error generando QR: %v", err)
+			respondError(w, http.StatusInternalServerError, "Error generando QR")
+			return
+		}
+		link.QRCode = "data:image/png;base64," + base64.StdEncoding.EncodeToString(png)
+	}
+
+	respondJSON(w, http.StatusCreated, link)
+	log.Printf("✓ Enlace compartido creado: %s/%s", userID, imageID)
+}
+
+func shareHandler(w http.ResponseWriter, r *http.Request) {
+	img, err := findSharedImage(r.Context(), chi.URLParam(r, "token"))
+	if err == sql.ErrNoRows {
+		respondError(w, http.StatusNotFound, "Enlace no encontrado")
+		return
+	}
+	if err != nil {
+		log.Printf("Error BD: %v", err)
+		respondError(w, http.StatusInternalServerError, "Error interno")
+		return
+	}
+
+	serveImage(w, r, img)
+}
+
+func shareQRHandler(w http.ResponseWriter, r *http.Request) {
+	token := chi.URLParam(r, "token")
+	if _, err := findSharedImage(r.Context(), token); err == sql.ErrNoRows {
+		respondError(w, http.StatusNotFound, "Enlace no encontrado")
+		return
+	} else if err != nil {
+		log.Printf("Error BD: %v", err)
+		respondError(w, http.StatusInternalServerError, "Error interno")
+		return
+	}
+
+	size := qrDefaultSize
+	if s := r.URL.Query().Get("size"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < qrMinSize || n > qrMaxSize {
+			respondError(w, http.StatusBadRequest, fmt.Sprintf("size debe estar entre %d y %d", qrMinSize, qrMaxSize))
+			return
+		}
+		size = n
+	}
+
+	png, err := qrcode.Encode(shareURL(r, token), qrcode.Medium, size)
+	if err != nil {
+		log.Printf("Error generando QR: %v", err)
+		respondError(w, http.StatusInternalServerError, "Error generando QR")
+		return
+	}
+
+	w.Header().Set("Content-Type", "image/png")
+	w.Header().Set("Content-Length", strconv.Itoa(len(png)))
+	w.Header().Set("Cache-Control", "public, max-age=86400")
+	w.Write(png)
+}
+
+// findSharedImage resuelve un token vigente a su imagen
+func findSharedImage(ctx context.Context, token string) (Image, error) {
+	query := `SELECT ` + imageColumns + ` FROM images
+			  WHERE deleted_at IS NULL AND (id, user_id) IN (
+				SELECT image_id, user_id FROM share_links
+				WHERE token = ? AND (expires_at IS NULL OR expires_at > NOW()))`
+	return scanImage(db.QueryRowContext(ctx, query, token))
+}
+
+func newShareToken() (string, error) {
+	b := make([]byte, shareTokenBytes)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// shareURL arma la URL absoluta del enlace. El QR necesita una URL completa,
+// así que se usa PUBLIC_BASE_URL o, si no está, el host de la petición.
+func shareURL(r *http.Request, token string) string {
+	return baseURL(r) + "/s/" + token
+}
+
+func baseURL(r *http.Request) string {
+	if publicBaseURL != "" {
+		return strings.TrimRight(publicBaseURL, "/")
+	}
+	scheme := "http"
+	if r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https" {
+		scheme = "https"
+	}
+	return scheme + "://" + r.Host
+}
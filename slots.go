@@ -0,0 +1,150 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// Slots con nombre (avatar, cover, ...) que apuntan a una imagen del
+// usuario, para que las apps pidan "el avatar actual" sin guardar IDs
+
+var slotNamePattern = regexp.MustCompile(`^[a-z0-9_-]{1,50}$`)
+
+type Slot struct {
+	UserID    string    `json:"user_id"`
+	Slot      string    `json:"slot"`
+	ImageID   string    `json:"image_id"`
+	URL       string    `json:"url"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+type assignSlotRequest struct {
+	ImageID string `json:"image_id"`
+}
+
+func assignSlotHandler(w http.ResponseWriter, r *http.Request) {
+	userID := chi.URLParam(r, "userId")
+	slot := chi.URLParam(r, "slot")
+	if !slotNamePattern.MatchString(slot) {
+		respondError(w, http.StatusBadRequest, "Nombre de slot inválido (a-z, 0-9, _ y -, hasta 50)")
+		return
+	}
+
+	var req assignSlotRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.ImageID == "" {
+		respondError(w, http.StatusBadRequest, "image_id es requerido")
+		return
+	}
+
+	if _, err := findImage(r.Context(), userID, req.ImageID); err == sql.ErrNoRows {
+		respondError(w, http.StatusNotFound, "Imagen no encontrada")
+		return
+	} else if err != nil {
+		log.Printf("Error BD: %v", err)
+		respondError(w, http.StatusInternalServerError, "Error consultando BD")
+		return
+	}
+
+	query := `INSERT INTO image_slots (user_id, slot, image_id) VALUES (?, ?, ?)
+			  ON DUPLICATE KEY UPDATE image_id = VALUES(image_id)`
+	if _, err := db.ExecContext(r.Context(), query, userID, slot, req.ImageID); err != nil {
+		log.Printf("Error BD: %v", err)
+		respondError(w, http.StatusInternalServerError, "Error guardando slot")
+		return
+	}
+
+	respondJSON(w, http.StatusOK, Slot{
+		UserID:    userID,
+		Slot:      slot,
+		ImageID:   req.ImageID,
+		URL:       slotURL(userID, slot),
+		UpdatedAt: time.Now().UTC(),
+	})
+	log.Printf("✓ Slot %s/%s → %s", userID, slot, req.ImageID)
+}
+
+// slotImageHandler sirve la imagen asignada al slot. Como el contenido
+// cambia al reasignarlo, no se cachea como inmutable: el ETag de la imagen
+// permite revalidar barato.
+func slotImageHandler(w http.ResponseWriter, r *http.Request) {
+	userID := chi.URLParam(r, "userId")
+	slot := chi.URLParam(r, "slot")
+
+	query := `SELECT ` + imageColumns + ` FROM images
+			  WHERE user_id = ? AND deleted_at IS NULL AND id = (
+				SELECT image_id FROM image_slots WHERE user_id = ? AND slot = ?)`
+	img, err := scanImage(db.QueryRowContext(r.Context(), query, userID, userID, slot))
+	if err == sql.ErrNoRows {
+		respondError(w, http.StatusNotFound, "Slot vacío")
+		return
+	}
+	if err != nil {
+		log.Printf("Error BD: %v", err)
+		respondError(w, http.StatusInternalServerError, "Error interno")
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-cache")
+	serveImageOrTransform(w, r, img)
+}
+
+func listSlotsHandler(w http.ResponseWriter, r *http.Request) {
+	userID := chi.URLParam(r, "userId")
+
+	rows, err := db.QueryContext(r.Context(),
+		`SELECT slot, image_id, updated_at FROM image_slots WHERE user_id = ? ORDER BY slot`, userID)
+	if err != nil {
+		log.Printf("Error BD: %v", err)
+		respondError(w, http.StatusInternalServerError, "Error consultando BD")
+		return
+	}
+	defer rows.Close()
+
+	slots := make([]Slot, 0)
+	for rows.Next() {
+		s := Slot{UserID: userID}
+		if err := rows.Scan(&s.Slot, &s.ImageID, &s.UpdatedAt); err != nil {
+			log.Printf("Error escaneando fila: %v", err)
+			continue
+		}
+		s.URL = slotURL(userID, s.Slot)
+		slots = append(slots, s)
+	}
+
+	respondJSON(w, http.StatusOK, map[string]interface{}{
+		"user_id": userID,
+		"slots":   slots,
+	})
+}
+
+func clearSlotHandler(w http.ResponseWriter, r *http.Request) {
+	userID := chi.URLParam(r, "userId")
+	slot := chi.URLParam(r, "slot")
+
+	result, err := db.ExecContext(r.Context(), `DELETE FROM image_slots WHERE user_id = ? AND slot = ?`, userID, slot)
+	if err != nil {
+		log.Printf("Error BD: %v", err)
+		respondError(w, http.StatusInternalServerError, "Error eliminando slot")
+		return
+	}
+	if affected, _ := result.RowsAffected(); affected == 0 {
+		respondError(w, http.StatusNotFound, "Slot no encontrado")
+		return
+	}
+
+	respondJSON(w, http.StatusOK, map[string]interface{}{
+		"success": true,
+		"slot":    slot,
+	})
+}
+
+func slotURL(userID, slot string) string {
+	return fmt.Sprintf("/users/%s/slots/%s", userID, slot)
+}
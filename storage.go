@@ -0,0 +1,96 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Volume es un directorio raíz donde se guardan imágenes. Con varios
+// discos se configuran en UPLOAD_VOLUMES como "[nombre=]ruta[:peso]".
+type Volume struct {
+	Name   string
+	Path   string
+	Weight int
+}
+
+var (
+	volumes   []Volume
+	volumesMu sync.Mutex // serializa la elección para no amontonar escrituras
+)
+
+func parseVolumes(spec, uploadDir string) ([]Volume, error) {
+	if strings.TrimSpace(spec) == "" {
+		return []Volume{{Name: "default", Path: uploadDir, Weight: 1}}, nil
+	}
+
+	var result []Volume
+	seen := make(map[string]bool)
+	for _, item := range strings.Split(spec, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		v := Volume{Weight: 1}
+		if name, rest, ok := strings.Cut(item, "="); ok {
+			v.Name, item = name, rest
+		}
+		if i := strings.LastIndex(item, ":"); i > 0 {
+			w, err := strconv.Atoi(item[i+1:])
+			if err != nil || w <= 0 {
+				return nil, fmt.Errorf("peso inválido en %q", item)
+			}
+			v.Weight, item = w, item[:i]
+		}
+		v.Path = item
+		if v.Name == "" {
+			v.Name = v.Path
+		}
+		if seen[v.Name] {
+			return nil, fmt.Errorf("volumen duplicado: %s", v.Name)
+		}
+		seen[v.Name] = true
+		result = append(result, v)
+	}
+	if len(result) == 0 {
+		return nil, fmt.Errorf("no hay volúmenes configurados")
+	}
+	return result, nil
+}
+
+// pickVolume elige el volumen con más espacio libre ponderado por su peso.
+// Si no se puede leer el espacio libre de ningún volumen se usa el de
+// mayor peso.
+func pickVolume() (Volume, error) {
+	volumesMu.Lock()
+	defer volumesMu.Unlock()
+
+	best := -1
+	var bestScore float64
+	for i, v := range volumes {
+		free, err := diskFree(v.Path)
+		if err != nil {
+			log.Printf("Volumen %s no disponible: %v", v.Name, err)
+			continue
+		}
+		if score := float64(free) * float64(v.Weight); best < 0 || score > bestScore {
+			best, bestScore = i, score
+		}
+	}
+	if best >= 0 {
+		return volumes[best], nil
+	}
+
+	for i, v := range volumes {
+		if _, err := os.Stat(v.Path); err == nil && (best < 0 || v.Weight > volumes[best].Weight) {
+			best = i
+		}
+	}
+	if best < 0 {
+		return Volume{}, fmt.Errorf("ningún volumen disponible")
+	}
+	return volumes[best], nil
+}
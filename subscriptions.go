@@ -0,0 +1,506 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+)
+
+const (
+	eventWebhookTest = "webhook.test"
+
+	subscriptionActive = "active"
+	subscriptionPaused = "paused"
+
+	maxDeliveryRows = 200
+)
+
+// knownEventTypes son los eventos a los que se puede suscribir
+var knownEventTypes = map[string]bool{
+	eventQuotaThreshold: true,
+	eventWebhookTest:    true,
+}
+
+type WebhookSubscription struct {
+	ID            string     `json:"id"`
+	UserID        string     `json:"user_id"`
+	URL           string     `json:"url"`
+	Secret        string     `json:"secret,omitempty"` // solo al crear
+	Events        []string   `json:"events"`           // vacío = todos
+	Status        string     `json:"status"`
+	FailureCount  int        `json:"failure_count"`
+	LastSuccessAt *time.Time `json:"last_success_at,omitempty"`
+	LastFailureAt *time.Time `json:"last_failure_at,omitempty"`
+	LastError     string     `json:"last_error,omitempty"`
+	CreatedAt     time.Time  `json:"created_at"`
+}
+
+type WebhookDelivery struct {
+	ID         int64     `json:"id"`
+	EventID    string    `json:"event_id"`
+	EventType  string    `json:"event_type"`
+	Attempt    int       `json:"attempt"`
+	Success    bool      `json:"success"`
+	StatusCode *int      `json:"status_code,omitempty"`
+	Error      string    `json:"error,omitempty"`
+	DurationMS int       `json:"duration_ms"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// subscriptionRequest sirve para crear (url requerida) y para PATCH, donde
+// solo se aplican los campos presentes
+type subscriptionRequest struct {
+	URL    *string   `json:"url"`
+	Events *[]string `json:"events"`
+	Secret *string   `json:"secret"`
+	Status *string   `json:"status"`
+}
+
+const subscriptionColumns = `id, user_id, url, events, status, failure_count, last_success_at, last_failure_at,
+	COALESCE(last_error, ''), created_at`
+
+func scanSubscription(row rowScanner) (WebhookSubscription, error) {
+	var s WebhookSubscription
+	var events string
+	err := row.Scan(&s.ID, &s.UserID, &s.URL, &events, &s.Status, &s.FailureCount,
+		&s.LastSuccessAt, &s.LastFailureAt, &s.LastError, &s.CreatedAt)
+	s.Events = splitEvents(events)
+	return s, err
+}
+
+func splitEvents(v string) []string {
+	events := make([]string, 0)
+	for _, e := range strings.Split(v, ",") {
+		if e != "" {
+			events = append(events, e)
+		}
+	}
+	return events
+}
+
+func validateWebhookURL(raw string) error {
+	u, err := url.Parse(raw)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("url debe ser una URL http(s) absoluta")
+	}
+	if len(raw) > 1000 {
+		return fmt.Errorf("url excede 1000 caracteres")
+	}
+	// Los nombres se validan al conectar; acá solo se rechazan a tiempo las
+	// IPs literales y localhost
+	host := u.Hostname()
+	if ip := net.ParseIP(host); (ip != nil && isPrivateAddress(ip)) || strings.EqualFold(host, "localhost") {
+		return fmt.Errorf("url no puede apuntar a una dirección privada")
+	}
+	return nil
+}
+
+func validateEvents(events []string) error {
+	for _, e := range events {
+		if !knownEventTypes[e] {
+			return fmt.Errorf("evento desconocido: %s", e)
+		}
+	}
+	return nil
+}
+
+// maxSecretLength es el tamaño de webhook_subscriptions.secret
+const maxSecretLength = 100
+
+func validateSecret(secret string) error {
+	if utf8.RuneCountInString(secret) > maxSecretLength {
+		return fmt.Errorf("secret no puede superar %d caracteres", maxSecretLength)
+	}
+	return nil
+}
+
+func newWebhookSecret() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+func createSubscriptionHandler(w http.ResponseWriter, r *http.Request) {
+	userID := chi.URLParam(r, "userId")
+
+	var req subscriptionRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondError(w, http.StatusBadRequest, "JSON inválido")
+		return
+	}
+	if req.URL == nil {
+		respondError(w, http.StatusBadRequest, "url es requerida")
+		return
+	}
+	if err := validateWebhookURL(*req.URL); err != nil {
+		respondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	var events []string
+	if req.Events != nil {
+		events = uniqueStrings(*req.Events)
+	}
+	if err := validateEvents(events); err != nil {
+		respondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	secret := ""
+	if req.Secret != nil {
+		secret = *req.Secret
+	}
+	if err := validateSecret(secret); err != nil {
+		respondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if secret == "" {
+		var err error
+		if secret, err = newWebhookSecret(); err != nil {
+			log.Printf("Error generando secreto: %v", err)
+			respondError(w, http.StatusInternalServerError, "Error creando suscripción")
+			return
+		}
+	}
+
+	id := uuid.New().String()
+	query := `INSERT INTO webhook_subscriptions (id, user_id, url, secret, events, status) VALUES (?, ?, ?, ?, ?, ?)`
+	if _, err := db.ExecContext(r.Context(), query, id, userID, *req.URL, secret, strings.Join(events, ","), subscriptionActive); err != nil {
+		log.Printf("Error BD: %v", err)
+		respondError(w, http.StatusInternalServerError, "Error guardando suscripción")
+		return
+	}
+
+	sub, err := findSubscription(r.Context(), userID, id)
+	if err != nil {
+		log.Printf("Error BD: %v", err)
+		respondError(w, http.StatusInternalServerError, "Error consultando BD")
+		return
+	}
+	// El secreto se muestra una sola vez, para configurar la verificación
+	sub.Secret = secret
+	respondJSON(w, http.StatusCreated, sub)
+	log.Printf("✓ Webhook registrado para %s: %s", userID, *req.URL)
+}
+
+func listSubscriptionsHandler(w http.ResponseWriter, r *http.Request) {
+	userID := chi.URLParam(r, "userId")
+
+	rows, err := db.QueryContext(r.Context(), `SELECT `+subscriptionColumns+` FROM webhook_subscriptions
+		WHERE user_id = ? ORDER BY created_at`, userID)
+	if err != nil {
+		log.Printf("Error BD: %v", err)
+		respondError(w, http.StatusInternalServerError, "Error consultando BD")
+		return
+	}
+	defer rows.Close()
+
+	subs := make([]WebhookSubscription, 0)
+	for rows.Next() {
+		sub, err := scanSubscription(rows)
+		if err != nil {
+			log.Printf("Error escaneando fila: %v", err)
+			continue
+		}
+		subs = append(subs, sub)
+	}
+	respondJSON(w, http.StatusOK, subs)
+}
+
+func getSubscriptionHandler(w http.ResponseWriter, r *http.Request) {
+	sub, ok := subscriptionFromRequest(w, r)
+	if !ok {
+		return
+	}
+	respondJSON(w, http.StatusOK, sub)
+}
+
+// updateSubscriptionHandler cambia url, eventos o secreto, y pausa o
+// reactiva con status. Reactivar reinicia el contador de fallas.
+func updateSubscriptionHandler(w http.ResponseWriter, r *http.Request) {
+	sub, ok := subscriptionFromRequest(w, r)
+	if !ok {
+		return
+	}
+
+	var req subscriptionRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondError(w, http.StatusBadRequest, "JSON inválido")
+		return
+	}
+
+	var sets []string
+	var args []interface{}
+	if req.URL != nil {
+		if err := validateWebhookURL(*req.URL); err != nil {
+			respondError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		sets, args = append(sets, "url = ?"), append(args, *req.URL)
+	}
+	if req.Events != nil {
+		events := uniqueStrings(*req.Events)
+		if err := validateEvents(events); err != nil {
+			respondError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		sets, args = append(sets, "events = ?"), append(args, strings.Join(events, ","))
+	}
+	if req.Secret != nil {
+		if *req.Secret == "" {
+			respondError(w, http.StatusBadRequest, "secret no puede estar vacío")
+			return
+		}
+		if err := validateSecret(*req.Secret); err != nil {
+			respondError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		sets, args = append(sets, "secret = ?"), append(args, *req.Secret)
+	}
+	if req.Status != nil {
+		switch *req.Status {
+		case subscriptionActive:
+			sets = append(sets, "status = ?", "failure_count = 0")
+		case subscriptionPaused:
+			sets = append(sets, "status = ?")
+		default:
+			respondError(w, http.StatusBadRequest, "status debe ser active o paused")
+			return
+		}
+		args = append(args, *req.Status)
+	}
+	if len(sets) == 0 {
+		respondJSON(w, http.StatusOK, sub)
+		return
+	}
+
+	query := `UPDATE webhook_subscriptions SET ` + strings.Join(sets, ", ") + ` WHERE id = ? AND user_id = ?`
+	if _, err := db.ExecContext(r.Context(), query, append(args, sub.ID, sub.UserID)...); err != nil {
+		log.Printf("Error BD: %v", err)
+		respondError(w, http.StatusInternalServerError, "Error actualizando suscripción")
+		return
+	}
+
+	sub, err := findSubscription(r.Context(), sub.UserID, sub.ID)
+	if err != nil {
+		log.Printf("Error BD: %v", err)
+		respondError(w, http.StatusInternalServerError, "Error consultando BD")
+		return
+	}
+	respondJSON(w, http.StatusOK, sub)
+}
+
+func deleteSubscriptionHandler(w http.ResponseWriter, r *http.Request) {
+	sub, ok := subscriptionFromRequest(w, r)
+	if !ok {
+		return
+	}
+
+	tx, err := db.BeginTx(r.Context(), nil)
+	if err == nil {
+		defer tx.Rollback()
+		_, err = tx.ExecContext(r.Context(), `DELETE FROM webhook_deliveries WHERE subscription_id = ?`, sub.ID)
+	}
+	if err == nil {
+		_, err = tx.ExecContext(r.Context(), `DELETE FROM webhook_subscriptions WHERE id = ?`, sub.ID)
+	}
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		log.Printf("Error BD: %v", err)
+		respondError(w, http.StatusInternalServerError, "Error eliminando suscripción")
+		return
+	}
+
+	respondJSON(w, http.StatusOK, map[string]interface{}{
+		"success": true,
+		"id":      sub.ID,
+	})
+	log.Printf("✓ Webhook eliminado para %s: %s", sub.UserID, sub.URL)
+}
+
+// testSubscriptionHandler manda un evento de ejemplo, un solo intento y
+// de forma síncrona, y devuelve cómo respondió el destino. Funciona
+// aunque la suscripción esté pausada.
+func testSubscriptionHandler(w http.ResponseWriter, r *http.Request) {
+	sub, ok := subscriptionFromRequest(w, r)
+	if !ok {
+		return
+	}
+	target, err := subscriptionTarget(r.Context(), sub.ID)
+	if err != nil {
+		log.Printf("Error BD: %v", err)
+		respondError(w, http.StatusInternalServerError, "Error consultando BD")
+		return
+	}
+
+	event := Event{
+		ID:        uuid.New().String(),
+		Type:      eventWebhookTest,
+		UserID:    sub.UserID,
+		CreatedAt: time.Now().UTC(),
+		Data:      map[string]string{"message": "Evento de prueba", "subscription_id": sub.ID},
+	}
+	body, _ := json.Marshal(event)
+	attemptWebhook(target, event, body, 1)
+
+	delivery, err := lastDelivery(r.Context(), sub.ID, event.ID)
+	if err != nil {
+		log.Printf("Error BD: %v", err)
+		respondError(w, http.StatusInternalServerError, "Error consultando BD")
+		return
+	}
+	respondJSON(w, http.StatusOK, delivery)
+}
+
+func listDeliveriesHandler(w http.ResponseWriter, r *http.Request) {
+	sub, ok := subscriptionFromRequest(w, r)
+	if !ok {
+		return
+	}
+
+	limit := 50
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 || n > maxDeliveryRows {
+			respondError(w, http.StatusBadRequest, fmt.Sprintf("limit debe estar entre 1 y %d", maxDeliveryRows))
+			return
+		}
+		limit = n
+	}
+
+	rows, err := db.QueryContext(r.Context(), `SELECT `+deliveryColumns+` FROM webhook_deliveries
+		WHERE subscription_id = ? ORDER BY id DESC LIMIT ?`, sub.ID, limit)
+	if err != nil {
+		log.Printf("Error BD: %v", err)
+		respondError(w, http.StatusInternalServerError, "Error consultando BD")
+		return
+	}
+	defer rows.Close()
+
+	deliveries := make([]WebhookDelivery, 0)
+	for rows.Next() {
+		d, err := scanDelivery(rows)
+		if err != nil {
+			log.Printf("Error escaneando fila: %v", err)
+			continue
+		}
+		deliveries = append(deliveries, d)
+	}
+	respondJSON(w, http.StatusOK, deliveries)
+}
+
+func subscriptionFromRequest(w http.ResponseWriter, r *http.Request) (WebhookSubscription, bool) {
+	sub, err := findSubscription(r.Context(), chi.URLParam(r, "userId"), chi.URLParam(r, "id"))
+	if err == sql.ErrNoRows {
+		respondError(w, http.StatusNotFound, "Suscripción no encontrada")
+		return sub, false
+	}
+	if err != nil {
+		log.Printf("Error BD: %v", err)
+		respondError(w, http.StatusInternalServerError, "Error consultando BD")
+		return sub, false
+	}
+	return sub, true
+}
+
+func findSubscription(ctx context.Context, userID, id string) (WebhookSubscription, error) {
+	return scanSubscription(db.QueryRowContext(ctx, `SELECT `+subscriptionColumns+` FROM webhook_subscriptions
+		WHERE id = ? AND user_id = ?`, id, userID))
+}
+
+func subscriptionTarget(ctx context.Context, id string) (webhookTarget, error) {
+	t := webhookTarget{subscriptionID: id}
+	err := db.QueryRowContext(ctx, `SELECT url, secret FROM webhook_subscriptions WHERE id = ?`, id).Scan(&t.url, &t.secret)
+	return t, err
+}
+
+// subscribedTargets devuelve las suscripciones activas del usuario que
+// reciben el tipo de evento
+func subscribedTargets(userID, eventType string) ([]webhookTarget, error) {
+	rows, err := db.Query(`SELECT id, url, secret, events FROM webhook_subscriptions WHERE user_id = ? AND status = ?`,
+		userID, subscriptionActive)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var targets []webhookTarget
+	for rows.Next() {
+		var t webhookTarget
+		var events string
+		if err := rows.Scan(&t.subscriptionID, &t.url, &t.secret, &events); err != nil {
+			return nil, err
+		}
+		if events == "" || containsString(splitEvents(events), eventType) {
+			targets = append(targets, t)
+		}
+	}
+	return targets, rows.Err()
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// recordDelivery guarda el intento en el historial y actualiza el último
+// éxito o falla de la suscripción
+func recordDelivery(subscriptionID string, event Event, attempt, status int, deliveryErr error, elapsed time.Duration) {
+	var statusCode, errMsg interface{}
+	if status > 0 {
+		statusCode = status
+	}
+	if deliveryErr != nil {
+		errMsg = deliveryErr.Error()
+	}
+	_, err := db.Exec(`INSERT INTO webhook_deliveries (subscription_id, event_id, event_type, attempt, success, status_code, error, duration_ms)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+		subscriptionID, event.ID, event.Type, attempt, deliveryErr == nil, statusCode, errMsg, elapsed.Milliseconds())
+	if err != nil {
+		log.Printf("Error BD registrando entrega de webhook: %v", err)
+	}
+
+	if deliveryErr == nil {
+		_, err = db.Exec(`UPDATE webhook_subscriptions SET last_success_at = NOW(), failure_count = 0 WHERE id = ?`, subscriptionID)
+	} else {
+		_, err = db.Exec(`UPDATE webhook_subscriptions SET last_failure_at = NOW(), last_error = ?, failure_count = failure_count + 1
+			WHERE id = ?`, errMsg, subscriptionID)
+	}
+	if err != nil {
+		log.Printf("Error BD actualizando suscripción %s: %v", subscriptionID, err)
+	}
+}
+
+const deliveryColumns = `id, event_id, event_type, attempt, success, status_code, COALESCE(error, ''), duration_ms, created_at`
+
+func scanDelivery(row rowScanner) (WebhookDelivery, error) {
+	var d WebhookDelivery
+	err := row.Scan(&d.ID, &d.EventID, &d.EventType, &d.Attempt, &d.Success, &d.StatusCode, &d.Error, &d.DurationMS, &d.CreatedAt)
+	return d, err
+}
+
+func lastDelivery(ctx context.Context, subscriptionID, eventID string) (WebhookDelivery, error) {
+	return scanDelivery(db.QueryRowContext(ctx, `SELECT `+deliveryColumns+` FROM webhook_deliveries
+		WHERE subscription_id = ? AND event_id = ? ORDER BY id DESC LIMIT 1`, subscriptionID, eventID))
+}
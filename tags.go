@@ -0,0 +1,212 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// maxBulkTagIDs limita los ids explícitos; para más usar filter
+const maxBulkTagIDs = 1000
+
+var errTooManyTags = errors.New("demasiados tags")
+
+type BulkTagsRequest struct {
+	IDs    []string `json:"ids"`
+	Filter string   `json:"filter"` // mismos filtros que el listado, como query string
+	Add    []string `json:"add"`
+	Remove []string `json:"remove"`
+}
+
+type BulkTagsResponse struct {
+	UserID  string `json:"user_id"`
+	Matched int64  `json:"matched"`
+	Added   int64  `json:"added"`
+	Removed int64  `json:"removed"`
+}
+
+// bulkTagsHandler agrega y quita tags en muchas imágenes a la vez, elegidas
+// por ids, por filtro o por ambos. Todo corre en una transacción: si alguna
+// imagen pasaría el máximo de tags no se aplica nada.
+func bulkTagsHandler(w http.ResponseWriter, r *http.Request) {
+	userID := chi.URLParam(r, "userId")
+
+	var req BulkTagsRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondError(w, http.StatusBadRequest, "JSON inválido")
+		return
+	}
+
+	add, err := normalizeTags(req.Add)
+	if err == nil {
+		req.Remove, err = normalizeTags(req.Remove)
+	}
+	if err != nil {
+		respondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if len(add) == 0 && len(req.Remove) == 0 {
+		respondError(w, http.StatusBadRequest, "Indicar tags en add o remove")
+		return
+	}
+
+	ids := uniqueStrings(req.IDs)
+	if len(ids) == 0 && req.Filter == "" {
+		respondError(w, http.StatusBadRequest, "Indicar ids o filter")
+		return
+	}
+	if len(ids) > maxBulkTagIDs {
+		respondError(w, http.StatusBadRequest, fmt.Sprintf("Máximo %d ids por petición, usar filter para más", maxBulkTagIDs))
+		return
+	}
+
+	q, err := url.ParseQuery(req.Filter)
+	if err != nil {
+		respondError(w, http.StatusBadRequest, "filter inválido")
+		return
+	}
+	filters, args, err := parseListFilters(q)
+	if err != nil {
+		respondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	where := `user_id = ? AND deleted_at IS NULL` + filters
+	args = append([]interface{}{userID}, args...)
+	if len(ids) > 0 {
+		where += ` AND id IN (` + placeholders(len(ids)) + `)`
+		for _, id := range ids {
+			args = append(args, id)
+		}
+	}
+
+	resp, err := applyBulkTags(r.Context(), userID, where, args, add, req.Remove)
+	if errors.Is(err, errTooManyTags) {
+		respondError(w, http.StatusUnprocessableEntity, fmt.Sprintf("Alguna imagen superaría el máximo de %d tags", maxTagsPerImage))
+		return
+	}
+	if err != nil {
+		log.Printf("Error BD: %v", err)
+		respondError(w, http.StatusInternalServerError, "Error actualizando tags")
+		return
+	}
+
+	resp.UserID = userID
+	respondJSON(w, http.StatusOK, resp)
+	log.Printf("✓ Tags actualizados para %s: %d imágenes (+%d, -%d)", userID, resp.Matched, resp.Added, resp.Removed)
+}
+
+func applyBulkTags(ctx context.Context, userID, where string, args []interface{}, add, remove []string) (BulkTagsResponse, error) {
+	var resp BulkTagsResponse
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return resp, err
+	}
+	defer tx.Rollback()
+
+	// El contador del usuario se bloquea antes que las imágenes, en el mismo
+	// orden que journalCommit, para no cruzarse con una subida en un deadlock
+	if _, err := lockUserCounters(ctx, tx, userID); err != nil {
+		return resp, err
+	}
+
+	// Las imágenes se eligen una sola vez: reevaluar el filtro después de
+	// cada sentencia cambiaría el conjunto (filtrar por tag=old y quitar old
+	// dejaría sin imágenes al add). FOR UPDATE evita que otra petición las
+	// borre a mitad de camino.
+	ids, err := lockMatchedImages(ctx, tx, where, args)
+	if err != nil || len(ids) == 0 {
+		return resp, err
+	}
+	resp.Matched = int64(len(ids))
+
+	for _, chunk := range chunkStrings(ids, maxBulkTagIDs) {
+		chunkArgs := make([]interface{}, len(chunk))
+		for i, id := range chunk {
+			chunkArgs[i] = id
+		}
+		in := `(` + placeholders(len(chunk)) + `)`
+
+		for _, tag := range remove {
+			res, err := tx.ExecContext(ctx, `DELETE FROM image_tags WHERE tag = ? AND image_id IN `+in,
+				append([]interface{}{tag}, chunkArgs...)...)
+			if err != nil {
+				return resp, err
+			}
+			n, _ := res.RowsAffected()
+			resp.Removed += n
+		}
+
+		for _, tag := range add {
+			res, err := tx.ExecContext(ctx, `INSERT IGNORE INTO image_tags (image_id, user_id, tag)
+				SELECT id, user_id, ? FROM images WHERE id IN `+in, append([]interface{}{tag}, chunkArgs...)...)
+			if err != nil {
+				return resp, err
+			}
+			n, _ := res.RowsAffected()
+			resp.Added += n
+		}
+
+		if len(add) > 0 {
+			var over int
+			err := tx.QueryRowContext(ctx, `SELECT COUNT(*) FROM (
+					SELECT image_id FROM image_tags WHERE image_id IN `+in+`
+					GROUP BY image_id HAVING COUNT(*) > ?) AS over_limit`,
+				append(chunkArgs, maxTagsPerImage)...).Scan(&over)
+			if err != nil && err != sql.ErrNoRows {
+				return resp, err
+			}
+			if over > 0 {
+				return resp, errTooManyTags
+			}
+		}
+	}
+
+	if resp.Added+resp.Removed > 0 {
+		if err := recordChanges(ctx, tx, userID, ids, changeUpdated); err != nil {
+			return resp, err
+		}
+	}
+
+	return resp, tx.Commit()
+}
+
+// lockMatchedImages devuelve los ids que cumplen where, ordenados y
+// bloqueados hasta el final de tx
+func lockMatchedImages(ctx context.Context, tx *sql.Tx, where string, args []interface{}) ([]string, error) {
+	rows, err := tx.QueryContext(ctx, `SELECT id FROM images WHERE `+where+` ORDER BY id FOR UPDATE`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// chunkStrings parte values en tramos de a lo sumo size, para no pasar el
+// límite de placeholders de MySQL con filtros amplios
+func chunkStrings(values []string, size int) [][]string {
+	var chunks [][]string
+	for len(values) > size {
+		chunks = append(chunks, values[:size])
+		values = values[size:]
+	}
+	if len(values) > 0 {
+		chunks = append(chunks, values)
+	}
+	return chunks
+}
@@ -0,0 +1,269 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"golang.org/x/time/rate"
+)
+
+// throttleChunk es el máximo de bytes que se leen por espera del limitador;
+// también es el burst, así ninguna lectura supera lo que el bucket admite
+const throttleChunk = 32 << 10
+
+// throttleIdleTimeout reemplaza a TRANSFER_TIMEOUT en las transferencias
+// limitadas y en el export: mientras cada bloque pase antes de este plazo,
+// la transferencia sigue aunque en total dure más
+var throttleIdleTimeout = time.Minute
+
+const (
+	// clientLimiterIdle es cuánto se conserva el bucket de un cliente inactivo
+	clientLimiterIdle = 10 * time.Minute
+	maxClientLimiters = 10000
+)
+
+// BandwidthLimits en bytes por segundo; 0 = sin límite
+type BandwidthLimits struct {
+	UploadPerConn     int64 `json:"upload_per_conn_bps"`
+	UploadGlobal      int64 `json:"upload_global_bps"`
+	DownloadPerClient int64 `json:"download_per_client_bps"`
+	DownloadGlobal    int64 `json:"download_global_bps"`
+}
+
+// bandwidthLimitsPatch permite actualizar solo algunos límites vía admin
+type bandwidthLimitsPatch struct {
+	UploadPerConn     *int64 `json:"upload_per_conn_bps"`
+	UploadGlobal      *int64 `json:"upload_global_bps"`
+	DownloadPerClient *int64 `json:"download_per_client_bps"`
+	DownloadGlobal    *int64 `json:"download_global_bps"`
+}
+
+var throttle = struct {
+	sync.RWMutex
+	limits         BandwidthLimits
+	uploadGlobal   *rate.Limiter
+	downloadGlobal *rate.Limiter
+}{
+	uploadGlobal:   rate.NewLimiter(rate.Inf, throttleChunk),
+	downloadGlobal: rate.NewLimiter(rate.Inf, throttleChunk),
+}
+
+// priorityMaxBytes (DOWNLOAD_PRIORITY_MAX_BYTES): las respuestas de hasta
+// este tamaño (miniaturas, QR, imágenes chicas) van por la clase prioritaria
+// y no pasan por los limitadores de descarga
+var priorityMaxBytes int64
+
+// clientLimiters guarda un bucket de descarga por cliente (token de enlace
+// compartido o IP), compartido entre sus peticiones concurrentes
+var clientLimiters = struct {
+	sync.Mutex
+	m map[string]*clientLimiter
+}{m: make(map[string]*clientLimiter)}
+
+type clientLimiter struct {
+	limiter  *rate.Limiter
+	lastSeen time.Time
+}
+
+func bpsLimit(bps int64) rate.Limit {
+	if bps <= 0 {
+		return rate.Inf
+	}
+	return rate.Limit(bps)
+}
+
+func setBandwidthLimits(l BandwidthLimits) {
+	throttle.Lock()
+	defer throttle.Unlock()
+	throttle.limits = l
+	throttle.uploadGlobal.SetLimit(bpsLimit(l.UploadGlobal))
+	throttle.downloadGlobal.SetLimit(bpsLimit(l.DownloadGlobal))
+}
+
+func currentBandwidthLimits() BandwidthLimits {
+	throttle.RLock()
+	defer throttle.RUnlock()
+	return throttle.limits
+}
+
+// throttledReader limita la lectura con un bucket propio de la conexión y
+// uno global compartido. Relee el límite por conexión en cada Read para que
+// los cambios desde la API de admin apliquen también a subidas en curso.
+// Mientras haya límite, cada bloque posterga el deadline como en las
+// descargas: si no, toda subida mayor que rate×TRANSFER_TIMEOUT daría 408.
+type throttledReader struct {
+	ctx     context.Context
+	src     io.ReadCloser
+	perConn *rate.Limiter
+	global  *rate.Limiter
+	current func() int64
+}
+
+func (t *throttledReader) Read(p []byte) (int, error) {
+	if len(p) > throttleChunk {
+		p = p[:throttleChunk]
+	}
+	if limit := bpsLimit(t.current()); limit != t.perConn.Limit() {
+		t.perConn.SetLimit(limit)
+	}
+	if t.perConn.Limit() != rate.Inf || t.global.Limit() != rate.Inf {
+		extendDeadline(t.ctx, throttleIdleTimeout)
+	}
+
+	n, err := t.src.Read(p)
+	if n > 0 {
+		if werr := t.perConn.WaitN(t.ctx, n); werr != nil {
+			return n, werr
+		}
+		if werr := t.global.WaitN(t.ctx, n); werr != nil {
+			return n, werr
+		}
+	}
+	return n, err
+}
+
+func (t *throttledReader) Close() error { return t.src.Close() }
+
+// throttleUploads aplica los límites de ingreso al body de la petición
+func throttleUploads(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.Body = &throttledReader{
+			ctx:     r.Context(),
+			src:     r.Body,
+			perConn: rate.NewLimiter(bpsLimit(currentBandwidthLimits().UploadPerConn), throttleChunk),
+			global:  throttle.uploadGlobal,
+			current: func() int64 { return currentBandwidthLimits().UploadPerConn },
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// throttledWriter es el equivalente de salida: escribe de a throttleChunk
+// esperando en el bucket del cliente y en el global de descargas
+type throttledWriter struct {
+	http.ResponseWriter
+	ctx     context.Context
+	limiter *rate.Limiter
+	global  *rate.Limiter
+}
+
+func (t *throttledWriter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		chunk := p
+		if len(chunk) > throttleChunk {
+			chunk = chunk[:throttleChunk]
+		}
+		extendDeadline(t.ctx, throttleIdleTimeout)
+		if err := t.limiter.WaitN(t.ctx, len(chunk)); err != nil {
+			return written, err
+		}
+		if err := t.global.WaitN(t.ctx, len(chunk)); err != nil {
+			return written, err
+		}
+		n, err := t.ResponseWriter.Write(chunk)
+		written += n
+		if err != nil {
+			return written, err
+		}
+		p = p[len(chunk):]
+	}
+	return written, nil
+}
+
+// throttleDownload envuelve w si la respuesta es de la clase masiva; las
+// respuestas chicas se devuelven tal cual para que no esperen detrás de
+// alguien descargando toda su biblioteca
+func throttleDownload(w http.ResponseWriter, r *http.Request, size int64) http.ResponseWriter {
+	if size <= priorityMaxBytes {
+		return w
+	}
+	limits := currentBandwidthLimits()
+	if limits.DownloadPerClient <= 0 && limits.DownloadGlobal <= 0 {
+		return w
+	}
+
+	key := "ip:" + clientIP(r)
+	if token := chi.URLParam(r, "token"); token != "" {
+		key = "share:" + token
+	}
+	return &throttledWriter{
+		ResponseWriter: w,
+		ctx:            r.Context(),
+		limiter:        clientDownloadLimiter(key, limits.DownloadPerClient),
+		global:         throttle.downloadGlobal,
+	}
+}
+
+func clientDownloadLimiter(key string, bps int64) *rate.Limiter {
+	clientLimiters.Lock()
+	defer clientLimiters.Unlock()
+
+	now := time.Now()
+	if len(clientLimiters.m) >= maxClientLimiters {
+		for k, c := range clientLimiters.m {
+			if now.Sub(c.lastSeen) > clientLimiterIdle {
+				delete(clientLimiters.m, k)
+			}
+		}
+	}
+
+	c, ok := clientLimiters.m[key]
+	if !ok {
+		c = &clientLimiter{limiter: rate.NewLimiter(bpsLimit(bps), throttleChunk)}
+		clientLimiters.m[key] = c
+	} else if c.limiter.Limit() != bpsLimit(bps) {
+		c.limiter.SetLimit(bpsLimit(bps))
+	}
+	c.lastSeen = now
+	return c.limiter
+}
+
+// clientIP devuelve la IP de RemoteAddr sin el puerto
+func clientIP(r *http.Request) string {
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		return host
+	}
+	return r.RemoteAddr
+}
+
+func getThrottleHandler(w http.ResponseWriter, r *http.Request) {
+	respondJSON(w, http.StatusOK, currentBandwidthLimits())
+}
+
+func updateThrottleHandler(w http.ResponseWriter, r *http.Request) {
+	var patch bandwidthLimitsPatch
+	if err := json.NewDecoder(r.Body).Decode(&patch); err != nil {
+		respondError(w, http.StatusBadRequest, "JSON inválido")
+		return
+	}
+
+	limits := currentBandwidthLimits()
+	for _, f := range []struct {
+		src *int64
+		dst *int64
+	}{
+		{patch.UploadPerConn, &limits.UploadPerConn},
+		{patch.UploadGlobal, &limits.UploadGlobal},
+		{patch.DownloadPerClient, &limits.DownloadPerClient},
+		{patch.DownloadGlobal, &limits.DownloadGlobal},
+	} {
+		if f.src == nil {
+			continue
+		}
+		if *f.src < 0 {
+			respondError(w, http.StatusBadRequest, "Los límites no pueden ser negativos")
+			return
+		}
+		*f.dst = *f.src
+	}
+
+	setBandwidthLimits(limits)
+	respondJSON(w, http.StatusOK, limits)
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// withBandwidthLimits aplica límites durante un test y los restaura al final
+func withBandwidthLimits(t *testing.T, l BandwidthLimits) {
+	t.Helper()
+	prevLimits, prevPriority, prevIdle := currentBandwidthLimits(), priorityMaxBytes, throttleIdleTimeout
+	setBandwidthLimits(l)
+	priorityMaxBytes = 0
+	throttleIdleTimeout = time.Second
+	t.Cleanup(func() {
+		setBandwidthLimits(prevLimits)
+		priorityMaxBytes, throttleIdleTimeout = prevPriority, prevIdle
+		clientLimiters.Lock()
+		clientLimiters.m = make(map[string]*clientLimiter)
+		clientLimiters.Unlock()
+	})
+}
+
+// Una descarga limitada que tarda varias veces TRANSFER_TIMEOUT llega entera
+func TestThrottledDownloadOutlivesTransferTimeout(t *testing.T) {
+	const timeout = 300 * time.Millisecond
+	withBandwidthLimits(t, BandwidthLimits{DownloadPerClient: 64 << 10})
+	payload := bytes.Repeat([]byte("x"), 4*throttleChunk) // ~1,5 s a 64 KB/s
+
+	srv := httptest.NewServer(requestTimeout(timeout)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(throttleDownload(w, r, int64(len(payload))), ctxReader{r.Context(), bytes.NewReader(payload)})
+	})))
+	defer srv.Close()
+
+	start := time.Now()
+	resp, err := http.Get(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil || len(body) != len(payload) {
+		t.Fatalf("descarga cortada: %d de %d bytes (%v)", len(body), len(payload), err)
+	}
+	if elapsed := time.Since(start); elapsed < 3*timeout {
+		t.Fatalf("la descarga tardó %v: el limitador no se aplicó", elapsed)
+	}
+}
+
+// Las respuestas chicas no pasan por el limitador
+func TestThrottleDownloadSkipsPriorityClass(t *testing.T) {
+	withBandwidthLimits(t, BandwidthLimits{DownloadPerClient: 1024})
+	priorityMaxBytes = 1 << 20
+
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	if w := throttleDownload(rec, req, 1000); w != http.ResponseWriter(rec) {
+		t.Fatalf("una respuesta de la clase prioritaria se limitó")
+	}
+}
+
+// Una subida limitada que tarda varias veces TRANSFER_TIMEOUT se lee entera
+// en vez de fallar con timeout
+func TestThrottledUploadOutlivesTransferTimeout(t *testing.T) {
+	const timeout = 300 * time.Millisecond
+	withBandwidthLimits(t, BandwidthLimits{UploadPerConn: 64 << 10})
+	payload := bytes.Repeat([]byte("x"), 4*throttleChunk)
+
+	type result struct {
+		n   int64
+		err error
+	}
+	got := make(chan result, 1)
+	srv := httptest.NewServer(requestTimeout(timeout)(throttleUploads(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n, err := io.Copy(io.Discard, r.Body)
+		got <- result{n, err}
+	}))))
+	defer srv.Close()
+
+	resp, err := http.Post(srv.URL, "application/octet-stream", bytes.NewReader(payload))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if res := <-got; res.err != nil || res.n != int64(len(payload)) {
+		t.Fatalf("subida cortada: %d de %d bytes (%v)", res.n, len(payload), res.err)
+	}
+}
@@ -0,0 +1,247 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+var (
+	jsonTimeout     time.Duration // JSON_TIMEOUT, rutas de API
+	transferTimeout time.Duration // TRANSFER_TIMEOUT, subidas y descargas
+)
+
+// requestTimeout pone un deadline al contexto de la petición, que cancela
+// las consultas a BD y la copia de archivos. Si al responder el deadline ya
+// venció, el error del handler se reemplaza por un 503 explícito. También
+// acota la lectura del body: una subida que no llega a tiempo falla con
+// un error de timeout que el handler traduce a 408. Las transferencias
+// limitadas pueden correrlo con extendDeadline mientras avanzan.
+//
+// El deadline de lectura de la conexión solo se usa mientras falta body: una
+// vez leído entero, net/http sigue leyendo en segundo plano para detectar
+// que el cliente se fue, y ese deadline cancelaría la petición aunque el
+// contexto se haya postergado.
+func requestTimeout(d time.Duration) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx, cancel := withMovableDeadline(r.Context(), d)
+			defer cancel()
+
+			if r.Body != nil && r.Body != http.NoBody {
+				m := ctx.(*movableDeadline)
+				// No todos los writers lo soportan (p.ej. en tests); es opcional
+				m.setReadDeadline = http.NewResponseController(w).SetReadDeadline
+				m.setReadDeadline(m.at)
+				r.Body = &deadlineBody{ReadCloser: r.Body, m: m}
+			}
+
+			tw := &timeoutWriter{ResponseWriter: w, ctx: ctx}
+			next.ServeHTTP(tw, r.WithContext(ctx))
+
+			if !tw.wroteHeader && errors.Is(ctx.Err(), context.DeadlineExceeded) {
+				tw.WriteHeader(http.StatusServiceUnavailable)
+			}
+		})
+	}
+}
+
+// movableDeadline es un context.WithTimeout cuyo vencimiento se puede
+// postergar: una descarga limitada más grande que rate×TRANSFER_TIMEOUT no
+// se corta a mitad del body mientras siga avanzando. Tiene su propio canal
+// Done para que los contextos derivados hereden DeadlineExceeded.
+type movableDeadline struct {
+	context.Context
+	done chan struct{}
+
+	mu    sync.Mutex
+	at    time.Time
+	timer *time.Timer
+	err   error
+
+	// setReadDeadline mueve el deadline de lectura de la conexión junto con
+	// el del contexto; nil si no hay body o ya se leyó
+	setReadDeadline func(time.Time) error
+}
+
+type movableDeadlineKey struct{}
+
+func withMovableDeadline(parent context.Context, d time.Duration) (context.Context, context.CancelFunc) {
+	m := &movableDeadline{Context: parent, done: make(chan struct{}), at: time.Now().Add(d)}
+	m.timer = time.AfterFunc(d, func() { m.finish(context.DeadlineExceeded) })
+	stop := context.AfterFunc(parent, func() { m.finish(parent.Err()) })
+	return m, func() {
+		m.timer.Stop()
+		stop()
+		m.finish(context.Canceled)
+	}
+}
+
+func (m *movableDeadline) finish(err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.err == nil {
+		m.err = err
+		close(m.done)
+	}
+}
+
+func (m *movableDeadline) Deadline() (time.Time, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.at, true
+}
+
+func (m *movableDeadline) Done() <-chan struct{} {
+	return m.done
+}
+
+func (m *movableDeadline) Err() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.err
+}
+
+func (m *movableDeadline) Value(key interface{}) interface{} {
+	if key == (movableDeadlineKey{}) {
+		return m
+	}
+	return m.Context.Value(key)
+}
+
+// extendDeadline posterga el deadline de requestTimeout para que venza no
+// antes de d desde ahora. Nunca lo adelanta y no revive uno ya vencido.
+func extendDeadline(ctx context.Context, d time.Duration) {
+	m, ok := ctx.Value(movableDeadlineKey{}).(*movableDeadline)
+	if !ok {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	at := time.Now().Add(d)
+	if m.err != nil || !at.After(m.at) {
+		return
+	}
+	if m.timer.Stop() {
+		m.at = at
+		m.timer.Reset(d)
+		if m.setReadDeadline != nil {
+			m.setReadDeadline(at)
+		}
+	}
+}
+
+// bodyConsumed quita el deadline de lectura al terminar el body; desde ahí
+// solo manda el del contexto
+func (m *movableDeadline) bodyConsumed() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.setReadDeadline != nil {
+		m.setReadDeadline(time.Time{})
+		m.setReadDeadline = nil
+	}
+}
+
+// deadlineBody avisa a movableDeadline cuando el body llega a EOF
+type deadlineBody struct {
+	io.ReadCloser
+	m *movableDeadline
+}
+
+func (b *deadlineBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if err == io.EOF {
+		b.m.bodyConsumed()
+	}
+	return n, err
+}
+
+// progressWriter posterga el deadline con cada escritura: una respuesta
+// larga que sigue avanzando no se corta, una que se traba sí
+type progressWriter struct {
+	ctx context.Context
+	w   io.Writer
+}
+
+func (p progressWriter) Write(b []byte) (int, error) {
+	extendDeadline(p.ctx, throttleIdleTimeout)
+	return p.w.Write(b)
+}
+
+// timeoutWriter convierte en 503 las respuestas 5xx escritas después de
+// vencido el deadline y descarta su body
+type timeoutWriter struct {
+	http.ResponseWriter
+	ctx         context.Context
+	wroteHeader bool
+	timedOut    bool
+}
+
+func (tw *timeoutWriter) WriteHeader(code int) {
+	if tw.wroteHeader {
+		return
+	}
+	// Los 1xx (Early Hints) no son la respuesta final
+	if code >= 100 && code < 200 {
+		tw.ResponseWriter.WriteHeader(code)
+		return
+	}
+	tw.wroteHeader = true
+
+	if code >= 500 && errors.Is(tw.ctx.Err(), context.DeadlineExceeded) {
+		tw.timedOut = true
+		h := tw.ResponseWriter.Header()
+		h.Del("Content-Length")
+		h.Del("ETag")
+		respondError(tw.ResponseWriter, http.StatusServiceUnavailable, "Tiempo de espera agotado")
+		return
+	}
+	tw.ResponseWriter.WriteHeader(code)
+}
+
+func (tw *timeoutWriter) Write(p []byte) (int, error) {
+	if !tw.wroteHeader {
+		tw.WriteHeader(http.StatusOK)
+	}
+	if tw.timedOut {
+		return len(p), nil
+	}
+	return tw.ResponseWriter.Write(p)
+}
+
+func (tw *timeoutWriter) Flush() {
+	if f, ok := tw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Unwrap permite a http.ResponseController llegar al writer original
+func (tw *timeoutWriter) Unwrap() http.ResponseWriter {
+	return tw.ResponseWriter
+}
+
+// ctxReader corta la lectura en cuanto se cancela el contexto
+type ctxReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (c ctxReader) Read(p []byte) (int, error) {
+	if err := c.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return c.r.Read(p)
+}
+
+// isTimeout detecta errores por deadline de lectura del body
+func isTimeout(err error) bool {
+	if errors.Is(err, os.ErrDeadlineExceeded) || errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+	var t interface{ Timeout() bool }
+	return errors.As(err, &t) && t.Timeout()
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// La descarga dura el triple del timeout pero posterga el deadline con cada
+// bloque: no se debe cortar ni cancelar el contexto
+func TestRequestTimeoutExtendedPastDeadline(t *testing.T) {
+	const timeout = 200 * time.Millisecond
+	chunk := bytes.Repeat([]byte("x"), 1024)
+
+	handlerErr := make(chan error, 1)
+	srv := httptest.NewServer(requestTimeout(timeout)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for i := 0; i < 6; i++ {
+			time.Sleep(timeout / 2)
+			extendDeadline(r.Context(), timeout)
+			w.Write(chunk)
+			w.(http.Flusher).Flush()
+		}
+		handlerErr <- r.Context().Err()
+	})))
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		t.Fatalf("body cortado tras %d bytes: %v", len(body), err)
+	}
+	if len(body) != 6*len(chunk) {
+		t.Fatalf("body de %d bytes, se esperaban %d", len(body), 6*len(chunk))
+	}
+	if err := <-handlerErr; err != nil {
+		t.Fatalf("contexto cancelado durante la descarga: %v", err)
+	}
+}
+
+// Sin extensiones el deadline sigue cortando la petición
+func TestRequestTimeoutExpires(t *testing.T) {
+	const timeout = 100 * time.Millisecond
+
+	handlerErr := make(chan error, 1)
+	srv := httptest.NewServer(requestTimeout(timeout)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-time.After(5 * timeout):
+		}
+		handlerErr <- r.Context().Err()
+	})))
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if err := <-handlerErr; !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("se esperaba DeadlineExceeded, fue %v", err)
+	}
+	if resp.StatusCode != http.StatusServiceUnavailable {
+		t.Fatalf("status %d, se esperaba 503", resp.StatusCode)
+	}
+}
+
+// Un body que llega más lento que el timeout pero posterga el deadline en
+// cada lectura se lee entero: el deadline de lectura de la conexión se
+// mueve junto con el del contexto
+func TestRequestTimeoutExtendsReadDeadline(t *testing.T) {
+	const timeout = 200 * time.Millisecond
+
+	got := make(chan int, 1)
+	srv := httptest.NewServer(requestTimeout(timeout)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		buf := make([]byte, 1024)
+		total := 0
+		for {
+			extendDeadline(r.Context(), timeout)
+			n, err := r.Body.Read(buf)
+			total += n
+			if err != nil {
+				break
+			}
+		}
+		got <- total
+	})))
+	defer srv.Close()
+
+	pr, pw := io.Pipe()
+	go func() {
+		for i := 0; i < 6; i++ {
+			time.Sleep(timeout / 2)
+			pw.Write(make([]byte, 100))
+		}
+		pw.Close()
+	}()
+	resp, err := http.Post(srv.URL, "application/octet-stream", pr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if total := <-got; total != 600 {
+		t.Fatalf("se leyeron %d bytes, se esperaban 600", total)
+	}
+}
+
+func TestExtendDeadlineNeverShortens(t *testing.T) {
+	ctx, cancel := withMovableDeadline(context.Background(), time.Hour)
+	defer cancel()
+	before, _ := ctx.Deadline()
+	extendDeadline(ctx, time.Minute)
+	if after, _ := ctx.Deadline(); !after.Equal(before) {
+		t.Fatalf("el deadline se adelantó de %v a %v", before, after)
+	}
+}
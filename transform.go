@@ -0,0 +1,320 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+const (
+	maxTransformSize = 4096
+	jpegQuality      = 85
+	renditionsDir    = ".renditions"
+)
+
+// transformSigningKey (TRANSFORM_SIGNING_KEY): si está configurada, toda
+// transformación debe venir firmada con HMAC para que no se puedan pedir
+// tamaños arbitrarios y saturar la CPU
+var transformSigningKey []byte
+
+// transformParams son los parámetros de ?w=&h=&fit= de la descarga
+type transformParams struct {
+	Width  int
+	Height int
+	Fit    string // contain | cover | fill
+}
+
+// parseTransform devuelve ok=false si la petición no pide transformación
+func parseTransform(q url.Values) (transformParams, bool, error) {
+	p := transformParams{Fit: q.Get("fit")}
+	for _, d := range []struct {
+		param string
+		dst   *int
+	}{{"w", &p.Width}, {"h", &p.Height}} {
+		v := q.Get(d.param)
+		if v == "" {
+			continue
+		}
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 || n > maxTransformSize {
+			return p, false, fmt.Errorf("%s debe estar entre 1 y %d", d.param, maxTransformSize)
+		}
+		*d.dst = n
+	}
+
+	if p.Width == 0 && p.Height == 0 {
+		if p.Fit != "" {
+			return p, false, fmt.Errorf("fit requiere w o h")
+		}
+		return p, false, nil
+	}
+
+	switch p.Fit {
+	case "":
+		p.Fit = "contain"
+	case "contain":
+	case "cover", "fill":
+		if p.Width == 0 || p.Height == 0 {
+			return p, false, fmt.Errorf("fit=%s requiere w y h", p.Fit)
+		}
+	default:
+		return p, false, fmt.Errorf("fit debe ser contain, cover o fill")
+	}
+	return p, true, nil
+}
+
+// canonical es la forma estable de los parámetros, usada para firmar y
+// como nombre de la rendition en cache
+func (p transformParams) canonical() string {
+	parts := []string{"fit=" + p.Fit}
+	if p.Height > 0 {
+		parts = append(parts, "h="+strconv.Itoa(p.Height))
+	}
+	if p.Width > 0 {
+		parts = append(parts, "w="+strconv.Itoa(p.Width))
+	}
+	return strings.Join(parts, "&")
+}
+
+func (p transformParams) key() string {
+	return fmt.Sprintf("%dx%d-%s", p.Width, p.Height, p.Fit)
+}
+
+// transformSignature firma la ruta más los parámetros canónicos, para que
+// la firma de una imagen o tamaño no sirva para otro
+func transformSignature(path string, p transformParams) string {
+	mac := hmac.New(sha256.New, transformSigningKey)
+	mac.Write([]byte(path + "?" + p.canonical()))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil)[:16])
+}
+
+// transformPath es la ruta que se firma: sin el prefijo /v1, así la misma
+// firma vale con y sin él
+func transformPath(path string) string {
+	if strings.HasPrefix(path, "/v1/") {
+		return strings.TrimPrefix(path, "/v1")
+	}
+	return path
+}
+
+// isTransformPath acepta las dos rutas que sirven transformaciones: la
+// descarga por id y la de un slot
+func isTransformPath(path string) bool {
+	parts := strings.Split(strings.TrimPrefix(path, "/"), "/")
+	switch {
+	case len(parts) == 3 && parts[0] == "image":
+		return parts[1] != "" && parts[2] != ""
+	case len(parts) == 4 && parts[0] == "users" && parts[2] == "slots":
+		return parts[1] != "" && parts[3] != ""
+	}
+	return false
+}
+
+func verifyTransformSignature(path string, p transformParams, sig string) bool {
+	if len(transformSigningKey) == 0 {
+		return true
+	}
+	return hmac.Equal([]byte(sig), []byte(transformSignature(path, p)))
+}
+
+// serveImageOrTransform sirve el original o, si la query trae w/h/fit, la
+// rendition correspondiente tras validar la firma
+func serveImageOrTransform(w http.ResponseWriter, r *http.Request, img Image) {
+	params, ok, err := parseTransform(r.URL.Query())
+	if err != nil {
+		respondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if !ok {
+		serveImage(w, r, img)
+		return
+	}
+	if !verifyTransformSignature(transformPath(r.URL.Path), params, r.URL.Query().Get("s")) {
+		respondError(w, http.StatusForbidden, "Firma de transformación inválida")
+		return
+	}
+	serveTransformed(w, r, img, params)
+}
+
+// serveTransformed sirve la rendition desde cache o la genera
+func serveTransformed(w http.ResponseWriter, r *http.Request, img Image, p transformParams) {
+	rendition, err := renderTransform(r.Context(), img, p)
+	if err != nil {
+		log.Printf("Error transformando %s/%s: %v", img.UserID, img.ID, err)
+		message := "No se pudo transformar la imagen"
+		if errors.Is(err, errTooManyPixels) {
+			message = fmt.Sprintf("La imagen excede el máximo de %d píxeles", maxPixels)
+		}
+		respondError(w, http.StatusUnprocessableEntity, message)
+		return
+	}
+	serveImageFile(w, r, rendition, generateETag(img.ID+"/"+p.key()))
+}
+
+// renderTransform devuelve la rendition como Image apuntando a su archivo
+// en cache, generándola si no existe
+func renderTransform(ctx context.Context, img Image, p transformParams) (Image, error) {
+	mimeType, ext := renditionFormat(img.MimeType)
+	path := filepath.Join(renditionDir(img), p.key()+ext)
+
+	// Las renditions son cache local aunque el original esté en S3
+	rendition := img
+	rendition.FilePath = path
+	rendition.Volume = ""
+	rendition.MimeType = mimeType
+	rendition.Filename = strings.TrimSuffix(img.Filename, filepath.Ext(img.Filename)) + ext
+
+	if info, err := os.Stat(path); err == nil {
+		rendition.SizeBytes = info.Size()
+		return rendition, nil
+	}
+
+	src, err := decodeImage(ctx, img)
+	if err != nil {
+		return Image{}, err
+	}
+	out := applyTransform(src, p)
+
+	var buf bytes.Buffer
+	if mimeType == "image/jpeg" {
+		err = jpeg.Encode(&buf, out, &jpeg.Options{Quality: jpegQuality})
+	} else {
+		err = png.Encode(&buf, out)
+	}
+	if err != nil {
+		return Image{}, err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return Image{}, err
+	}
+	size, _, err := writeFile(path, &buf)
+	if err != nil {
+		return Image{}, err
+	}
+	trackRendition(img.ID, p.key(), path, size)
+	rendition.SizeBytes = size
+	return rendition, nil
+}
+
+// renditionFormat mantiene JPEG y usa PNG para el resto (no hay encoder
+// de WebP y GIF perdería la paleta)
+func renditionFormat(mimeType string) (string, string) {
+	if mimeType == "image/jpeg" {
+		return "image/jpeg", ".jpg"
+	}
+	return "image/png", ".png"
+}
+
+func applyTransform(src image.Image, p transformParams) image.Image {
+	b := src.Bounds()
+	sw, sh := b.Dx(), b.Dy()
+
+	switch p.Fit {
+	case "fill":
+		return resizeImage(src, p.Width, p.Height)
+	case "cover":
+		// Recortar al centro con la proporción pedida y luego escalar
+		cw, ch := sw, sw*p.Height/p.Width
+		if ch > sh {
+			cw, ch = sh*p.Width/p.Height, sh
+		}
+		x0 := b.Min.X + (sw-cw)/2
+		y0 := b.Min.Y + (sh-ch)/2
+		if sub, ok := src.(interface {
+			SubImage(image.Rectangle) image.Image
+		}); ok {
+			src = sub.SubImage(image.Rect(x0, y0, x0+cw, y0+ch))
+		}
+		return resizeImage(src, p.Width, p.Height)
+	}
+
+	maxW, maxH := p.Width, p.Height
+	if maxW == 0 {
+		maxW = sw
+	}
+	if maxH == 0 {
+		maxH = sh
+	}
+	w, h := fitWithin(sw, sh, maxW, maxH)
+	if w == sw && h == sh {
+		return src
+	}
+	return resizeImage(src, w, h)
+}
+
+type SignRequest struct {
+	Path   string `json:"path"` // /image/{userId}/{id} o /users/{userId}/slots/{slot}, con o sin /v1
+	Width  int    `json:"w"`
+	Height int    `json:"h"`
+	Fit    string `json:"fit"`
+}
+
+// signTransformHandler firma URLs de transformación para servicios que
+// no implementan el HMAC por su cuenta
+func signTransformHandler(w http.ResponseWriter, r *http.Request) {
+	var req SignRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondError(w, http.StatusBadRequest, "JSON inválido")
+		return
+	}
+	if !isTransformPath(transformPath(req.Path)) {
+		respondError(w, http.StatusBadRequest, "path debe ser /image/{userId}/{id} o /users/{userId}/slots/{slot}")
+		return
+	}
+
+	q := url.Values{}
+	if req.Width > 0 {
+		q.Set("w", strconv.Itoa(req.Width))
+	}
+	if req.Height > 0 {
+		q.Set("h", strconv.Itoa(req.Height))
+	}
+	if req.Fit != "" {
+		q.Set("fit", req.Fit)
+	}
+	p, ok, err := parseTransform(q)
+	if err != nil {
+		respondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if !ok {
+		respondError(w, http.StatusBadRequest, "Se requiere w o h")
+		return
+	}
+
+	respondJSON(w, http.StatusOK, map[string]string{
+		"url": transformURL(req.Path, p),
+	})
+}
+
+// transformURL arma la URL de la transformación p sobre path, firmada si
+// hay clave
+func transformURL(path string, p transformParams) string {
+	q := url.Values{"fit": {p.Fit}}
+	if p.Width > 0 {
+		q.Set("w", strconv.Itoa(p.Width))
+	}
+	if p.Height > 0 {
+		q.Set("h", strconv.Itoa(p.Height))
+	}
+	if len(transformSigningKey) > 0 {
+		q.Set("s", transformSignature(transformPath(path), p))
+	}
+	return path + "?" + q.Encode()
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"net/url"
+	"testing"
+)
+
+func TestParseTransform(t *testing.T) {
+	cases := []struct {
+		query   string
+		want    transformParams
+		ok, err bool
+	}{
+		{query: "", ok: false},
+		{query: "w=200", want: transformParams{Width: 200, Fit: "contain"}, ok: true},
+		{query: "w=200&h=100&fit=cover", want: transformParams{Width: 200, Height: 100, Fit: "cover"}, ok: true},
+		{query: "h=4096", want: transformParams{Height: 4096, Fit: "contain"}, ok: true},
+		{query: "w=0", err: true},
+		{query: "w=4097", err: true},
+		{query: "w=abc", err: true},
+		{query: "fit=cover", err: true},
+		{query: "w=200&fit=cover", err: true},
+		{query: "w=200&fit=stretch", err: true},
+	}
+	for _, c := range cases {
+		q, _ := url.ParseQuery(c.query)
+		p, ok, err := parseTransform(q)
+		if (err != nil) != c.err {
+			t.Errorf("%q: error %v", c.query, err)
+			continue
+		}
+		if c.err {
+			continue
+		}
+		if ok != c.ok || (ok && p != c.want) {
+			t.Errorf("%q: %+v ok=%v, se esperaba %+v ok=%v", c.query, p, ok, c.want, c.ok)
+		}
+	}
+}
+
+func TestTransformURLRoundTrip(t *testing.T) {
+	saved := transformSigningKey
+	t.Cleanup(func() { transformSigningKey = saved })
+	transformSigningKey = []byte("secreto")
+
+	p := transformParams{Width: 300, Height: 200, Fit: "cover"}
+	u, err := url.Parse(transformURL("/v1/image/u1/a", p))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, ok, err := parseTransform(u.Query())
+	if err != nil || !ok || got != p {
+		t.Fatalf("parámetros %+v (%v), se esperaba %+v", got, err, p)
+	}
+	sig := u.Query().Get("s")
+	// La firma vale con y sin /v1, pero no para otra imagen ni otro tamaño
+	if !verifyTransformSignature(transformPath(u.Path), p, sig) || !verifyTransformSignature("/image/u1/a", p, sig) {
+		t.Fatal("firma rechazada")
+	}
+	if verifyTransformSignature("/image/u1/b", p, sig) {
+		t.Fatal("la firma sirvió para otra imagen")
+	}
+	if verifyTransformSignature("/image/u1/a", transformParams{Width: 3000, Height: 200, Fit: "cover"}, sig) {
+		t.Fatal("la firma sirvió para otro tamaño")
+	}
+}
+
+func TestIsTransformPath(t *testing.T) {
+	cases := map[string]bool{
+		"/image/u1/a":          true,
+		"/users/u1/slots/logo": true,
+		"/image/u1":            false,
+		"/image/u1/":           false,
+		"/users/u1/slots/":     false,
+		"/images/u1/a":         false,
+	}
+	for path, want := range cases {
+		if got := isTransformPath(path); got != want {
+			t.Errorf("isTransformPath(%s) = %v, se esperaba %v", path, got, want)
+		}
+	}
+}
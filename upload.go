@@ -0,0 +1,242 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+
+	"github.com/google/uuid"
+)
+
+// uploadError es el motivo, apto para el cliente, por el que no se guardó
+// un archivo; el detalle técnico queda en el log
+type uploadError struct {
+	status  int
+	code    string
+	message string
+}
+
+func (e *uploadError) Error() string { return e.message }
+
+// errTooLarge lo devuelve fileLimitReader al pasar el límite por archivo
+var errTooLarge = errors.New("archivo excede el tamaño máximo")
+
+func errFileTooLarge() *uploadError {
+	return &uploadError{http.StatusRequestEntityTooLarge, "FILE_TOO_LARGE", "excede tamaño máximo de " + formatSize(maxFileSize)}
+}
+
+var (
+	errQuotaExceeded = &uploadError{http.StatusForbidden, "QUOTA_EXCEEDED", "excede la cuota de almacenamiento"}
+	errLimitReached  = &uploadError{http.StatusForbidden, "LIMIT_REACHED", "se alcanzó el máximo de imágenes del usuario"}
+	errDuplicate     = &uploadError{http.StatusConflict, "DUPLICATE", "el usuario ya tiene esta imagen"}
+	errInvalidFormat = &uploadError{http.StatusUnsupportedMediaType, "INVALID_FORMAT", "formato no válido"}
+	errStorageSave   = &uploadError{http.StatusInternalServerError, "STORAGE_ERROR", "error guardando"}
+	errStorageWrite  = &uploadError{http.StatusInternalServerError, "STORAGE_ERROR", "error escribiendo"}
+	errDBSave        = &uploadError{http.StatusInternalServerError, "DB_ERROR", "error guardando en BD"}
+)
+
+// asUploadError normaliza cualquier error de guardado a un uploadError
+func asUploadError(err error) *uploadError {
+	var ue *uploadError
+	if errors.As(err, &ue) {
+		return ue
+	}
+	return errStorageSave
+}
+
+// saveImage escribe el archivo en un volumen y crea su registro en BD
+func saveImage(ctx context.Context, userID, originalName string, src io.Reader, meta imageMeta) (Image, error) {
+	img, err := storeImage(ctx, userID, originalName, src)
+	if err != nil {
+		return Image{}, err
+	}
+	return commitImage(ctx, img, meta)
+}
+
+// storeImage escribe el archivo en su backend sin crear todavía su
+// registro; /upload lo usa para guardar cada parte mientras llega y
+// registrar todas al final. Antes de tocar el disco se anota la intención
+// en pending_uploads, así un crash entre la escritura y el INSERT deja
+// rastro para recoverPendingUploads.
+func storeImage(ctx context.Context, userID, originalName string, src io.Reader) (Image, error) {
+	imageID := uuid.New().String()
+	originalName = sanitizeFilename(originalName)
+
+	// Obtener extensión
+	ext := filepath.Ext(originalName)
+	filename := imageID + ext
+
+	// Elegir backend; el local crea el directorio del usuario al escribir
+	backend, err := pickUploadBackend()
+	if err != nil {
+		log.Printf("Error eligiendo volumen: %v", err)
+		return Image{}, errStorageSave
+	}
+	key := backend.Key(userID, filename)
+
+	if err := journalBegin(ctx, imageID, userID, backend.Name(), key); err != nil {
+		log.Printf("Error BD: %v", err)
+		return Image{}, errDBSave
+	}
+
+	// Guardar imagen, cortando la copia apenas se pasa del límite sin
+	// confiar en el tamaño declarado
+	size, checksum, err := backend.Put(ctx, key, &fileLimitReader{r: src, remaining: maxFileSize})
+	var ue *uploadError
+	if errors.Is(err, errTooLarge) {
+		journalAbort(imageID, backend.Name(), key)
+		return Image{}, errFileTooLarge()
+	}
+	if errors.As(err, &ue) {
+		journalAbort(imageID, backend.Name(), key)
+		return Image{}, ue
+	}
+	if err != nil {
+		log.Printf("Error escribiendo %s en %s: %v", key, backend.Name(), err)
+		journalAbort(imageID, backend.Name(), key)
+		return Image{}, errStorageWrite
+	}
+
+	img := Image{
+		ID:        imageID,
+		UserID:    userID,
+		Filename:  originalName,
+		FilePath:  key,
+		MimeType:  getContentType(ext),
+		SizeBytes: size,
+		Checksum:  checksum,
+		Volume:    backend.Name(),
+		URL:       fmt.Sprintf("/image/%s/%s", userID, imageID),
+	}
+
+	// Dimensiones (NULL si no se pueden leer)
+	if width, height, err := storedImageDimensions(ctx, img); err == nil {
+		img.Width, img.Height = &width, &height
+	} else {
+		log.Printf("No se pudieron leer dimensiones de %s: %v", key, err)
+	}
+	return img, nil
+}
+
+// commitImage registra una imagen escrita por storeImage. Si falla, el
+// archivo se descarta.
+func commitImage(ctx context.Context, img Image, meta imageMeta) (Image, error) {
+	img.Caption, img.AlbumID, img.Tags, img.Metadata = meta.Caption, meta.AlbumID, meta.Tags, meta.Metadata
+
+	// Guardar en BD y cerrar la intención en la misma transacción
+	if err := journalCommit(ctx, &img, meta.skipDuplicate); err != nil {
+		journalAbort(img.ID, img.Volume, img.FilePath)
+		var ue *uploadError
+		if errors.As(err, &ue) {
+			return Image{}, ue
+		}
+		log.Printf("Error BD: %v", err)
+		return Image{}, errDBSave
+	}
+
+	log.Printf("✓ Imagen guardada: %s/%s (%d bytes)", img.UserID, filepath.Base(img.FilePath), img.SizeBytes)
+	return img, nil
+}
+
+// discardImage descarta un archivo escrito por storeImage que no se va a
+// registrar
+func discardImage(img Image) {
+	journalAbort(img.ID, img.Volume, img.FilePath)
+}
+
+// fileLimitReader es como http.MaxBytesReader para un solo archivo: deja
+// pasar hasta remaining bytes y falla con err (errTooLarge si no se indica)
+// en cuanto llega uno más
+type fileLimitReader struct {
+	r         io.Reader
+	remaining int64
+	err       error
+}
+
+func (l *fileLimitReader) Read(p []byte) (int, error) {
+	if l.remaining < 0 {
+		return 0, l.limitErr()
+	}
+	// Leer a lo sumo un byte más que el restante para detectar el exceso
+	if int64(len(p)) > l.remaining+1 {
+		p = p[:l.remaining+1]
+	}
+	n, err := l.r.Read(p)
+	l.remaining -= int64(n)
+	if l.remaining < 0 {
+		return 0, l.limitErr()
+	}
+	return n, err
+}
+
+func (l *fileLimitReader) limitErr() error {
+	if l.err != nil {
+		return l.err
+	}
+	return errTooLarge
+}
+
+// bodyReader recuerda el error de lectura de una parte del formulario, para
+// distinguir una subida cortada de un error al escribir en disco
+type bodyReader struct {
+	r   io.Reader
+	err error
+}
+
+func (b *bodyReader) Read(p []byte) (int, error) {
+	n, err := b.r.Read(p)
+	if err != nil && err != io.EOF {
+		b.err = err
+	}
+	return n, err
+}
+
+// writeFile escribe primero en "<path>.<aleatorio>.part" y renombra al
+// terminar, así un archivo a medio escribir nunca queda con el nombre
+// definitivo. Cada escritura usa su propio temporal: dos que compiten por
+// el mismo path (p. ej. la misma rendition) no se pisan y gana la última
+// en renombrar. Devuelve el tamaño y el sha256 en hex calculado durante la
+// copia.
+func writeFile(path string, src io.Reader) (int64, string, error) {
+	destFile, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*"+partialSuffix)
+	if err != nil {
+		return 0, "", err
+	}
+	partPath := destFile.Name()
+	// CreateTemp crea con 0600; se deja como lo dejaba os.Create
+	if err := destFile.Chmod(0644); err != nil {
+		destFile.Close()
+		os.Remove(partPath)
+		return 0, "", err
+	}
+
+	hash := sha256.New()
+	size, err := io.Copy(io.MultiWriter(destFile, hash), src)
+	if cerr := destFile.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(partPath, path)
+	}
+	if err != nil {
+		os.Remove(partPath) // Limpiar archivo incompleto
+		return 0, "", err
+	}
+	return size, hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+func fileChecksum(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	return readChecksum(file)
+}
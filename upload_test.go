@@ -0,0 +1,48 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestFileLimitReader(t *testing.T) {
+	// Justo en el límite se lee entero
+	data, err := io.ReadAll(&fileLimitReader{r: strings.NewReader("12345"), remaining: 5})
+	if err != nil || string(data) != "12345" {
+		t.Fatalf("leído %q, error %v", data, err)
+	}
+
+	// Un byte de más corta con errTooLarge
+	_, err = io.ReadAll(&fileLimitReader{r: strings.NewReader("123456"), remaining: 5})
+	if !errors.Is(err, errTooLarge) {
+		t.Fatalf("se esperaba errTooLarge, fue %v", err)
+	}
+}
+
+// El error del body (p. ej. http.MaxBytesReader) tiene prioridad sobre el
+// del archivo, y la copia no se pasa del límite más uno
+func TestFileLimitReaderStopsEarly(t *testing.T) {
+	bodyErr := errors.New("body demasiado grande")
+	src := &countingReader{r: bytes.NewReader(make([]byte, 1<<20))}
+	_, err := io.Copy(io.Discard, &fileLimitReader{r: src, remaining: 1000, err: bodyErr})
+	if !errors.Is(err, bodyErr) {
+		t.Fatalf("se esperaba el error del body, fue %v", err)
+	}
+	if src.n > 1001 {
+		t.Fatalf("se leyeron %d bytes con un límite de 1000", src.n)
+	}
+}
+
+type countingReader struct {
+	r io.Reader
+	n int
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += n
+	return n, err
+}
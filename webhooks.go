@@ -0,0 +1,255 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	webhookTimeout  = 10 * time.Second
+	webhookAttempts = 3
+)
+
+var (
+	webhookURLs   []string // WEBHOOK_URLS, separadas por coma
+	webhookSecret string   // WEBHOOK_SECRET, firma HMAC-SHA256 del body
+
+	// configuredWebhookClient entrega a WEBHOOK_URLS, que fija el operador
+	// y puede apuntar a la red interna
+	configuredWebhookClient = &http.Client{Timeout: webhookTimeout}
+	// webhookClient entrega a las URLs de las suscripciones, que elige el
+	// usuario: no sigue redirecciones y no conecta a direcciones privadas
+	webhookClient = &http.Client{
+		Timeout:   webhookTimeout,
+		Transport: publicOnlyTransport(),
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+)
+
+// errBlockedAddress lo devuelve el dialer de webhookClient al rechazar una
+// dirección
+var errBlockedAddress = errors.New("destino no permitido")
+
+// publicOnlyTransport valida la IP ya resuelta al conectar, así un DNS que
+// cambia después de crear la suscripción no la hace apuntar a la red interna
+func publicOnlyTransport() *http.Transport {
+	dialer := &net.Dialer{
+		Timeout: webhookTimeout,
+		Control: func(network, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			if ip := net.ParseIP(host); ip == nil || isPrivateAddress(ip) {
+				return fmt.Errorf("%w: %s", errBlockedAddress, host)
+			}
+			return nil
+		},
+	}
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	t.Proxy = nil // con proxy se validaría la IP del proxy y no la del destino
+	t.DialContext = dialer.DialContext
+	return t
+}
+
+// blockedNetworks son rangos no públicos que net.IP no clasifica: el
+// espacio compartido de CGNAT (RFC 6598) y "esta red" (RFC 1122), que en
+// Linux llega a localhost
+var blockedNetworks = []*net.IPNet{
+	mustParseCIDR("100.64.0.0/10"),
+	mustParseCIDR("0.0.0.0/8"),
+}
+
+func mustParseCIDR(s string) *net.IPNet {
+	_, n, err := net.ParseCIDR(s)
+	if err != nil {
+		panic(err)
+	}
+	return n
+}
+
+// isPrivateAddress cubre loopback, redes privadas, CGNAT, link-local
+// (incluida la metadata de los proveedores cloud) y la dirección no
+// especificada
+func isPrivateAddress(ip net.IP) bool {
+	if ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() ||
+		ip.IsLinkLocalMulticast() || ip.IsInterfaceLocalMulticast() || ip.IsUnspecified() {
+		return true
+	}
+	for _, n := range blockedNetworks {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// webhookStatusError es una respuesta del destino fuera de 2xx
+type webhookStatusError struct {
+	status int
+}
+
+func (e *webhookStatusError) Error() string {
+	return fmt.Sprintf("status %d", e.status)
+}
+
+// publicDeliveryError es la versión del error que ve el dueño de la
+// suscripción en /test y en el historial: el error completo (resolución,
+// conexión, TLS) queda solo en el log
+func publicDeliveryError(err error) error {
+	var statusErr *webhookStatusError
+	switch {
+	case err == nil:
+		return nil
+	case errors.As(err, &statusErr):
+		return statusErr
+	case errors.Is(err, errBlockedAddress):
+		return errBlockedAddress
+	case isTimeout(err):
+		return errors.New("tiempo de espera agotado")
+	default:
+		return errors.New("error de conexión")
+	}
+}
+
+type Event struct {
+	ID        string      `json:"id"`
+	Type      string      `json:"type"`
+	UserID    string      `json:"user_id"`
+	CreatedAt time.Time   `json:"created_at"`
+	Data      interface{} `json:"data"`
+}
+
+func parseWebhookURLs(v string) []string {
+	var urls []string
+	for _, u := range strings.Split(v, ",") {
+		if u = strings.TrimSpace(u); u != "" {
+			urls = append(urls, u)
+		}
+	}
+	return urls
+}
+
+// webhookTarget es un destino de entrega: los globales de WEBHOOK_URLS o
+// una suscripción de usuario, cuyas entregas quedan registradas
+type webhookTarget struct {
+	url            string
+	secret         string
+	subscriptionID string
+}
+
+// emitEvent envía el evento a los webhooks configurados en segundo plano;
+// nunca bloquea ni falla la petición que lo origina
+func emitEvent(eventType, userID string, data interface{}) {
+	event := Event{
+		ID:        uuid.New().String(),
+		Type:      eventType,
+		UserID:    userID,
+		CreatedAt: time.Now().UTC(),
+		Data:      data,
+	}
+	log.Printf("→ Evento %s (%s)", event.Type, event.UserID)
+
+	body, err := json.Marshal(event)
+	if err != nil {
+		log.Printf("Error serializando evento: %v", err)
+		return
+	}
+	for _, u := range webhookURLs {
+		go deliverWebhook(webhookTarget{url: u, secret: webhookSecret}, event, body)
+	}
+	go func() {
+		targets, err := subscribedTargets(userID, eventType)
+		if err != nil {
+			log.Printf("Error BD buscando suscripciones de %s: %v", userID, err)
+			return
+		}
+		for _, t := range targets {
+			go deliverWebhook(t, event, body)
+		}
+	}()
+}
+
+// deliverWebhook reintenta con backoff exponencial ante errores de red, 5xx,
+// 408 y 429. El resto de las respuestas (otros 4xx, redirecciones) y los
+// destinos bloqueados no van a cambiar reintentando.
+func deliverWebhook(target webhookTarget, event Event, body []byte) {
+	backoff := time.Second
+	for attempt := 1; attempt <= webhookAttempts; attempt++ {
+		err := attemptWebhook(target, event, body, attempt)
+		if err == nil {
+			return
+		}
+		log.Printf("Webhook %s intento %d/%d falló: %v", target.url, attempt, webhookAttempts, err)
+		if !retryableWebhookError(err) {
+			return
+		}
+		if attempt < webhookAttempts {
+			time.Sleep(backoff)
+			backoff *= 2
+		}
+	}
+}
+
+func retryableWebhookError(err error) bool {
+	var statusErr *webhookStatusError
+	if errors.As(err, &statusErr) {
+		s := statusErr.status
+		return s >= 500 || s == http.StatusRequestTimeout || s == http.StatusTooManyRequests
+	}
+	return !errors.Is(err, errBlockedAddress)
+}
+
+// attemptWebhook hace un envío y, si es de una suscripción, lo registra
+func attemptWebhook(target webhookTarget, event Event, body []byte, attempt int) error {
+	start := time.Now()
+	status, err := postWebhook(target, event, body)
+	if target.subscriptionID != "" {
+		recordDelivery(target.subscriptionID, event, attempt, status, publicDeliveryError(err), time.Since(start))
+	}
+	return err
+}
+
+func postWebhook(target webhookTarget, event Event, body []byte) (int, error) {
+	req, err := http.NewRequest(http.MethodPost, target.url, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Event-Type", event.Type)
+	req.Header.Set("X-Event-ID", event.ID)
+	if target.secret != "" {
+		mac := hmac.New(sha256.New, []byte(target.secret))
+		mac.Write(body)
+		req.Header.Set("X-Webhook-Signature", "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	}
+
+	client := webhookClient
+	if target.subscriptionID == "" {
+		client = configuredWebhookClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return resp.StatusCode, &webhookStatusError{resp.StatusCode}
+	}
+	return resp.StatusCode, nil
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"net"
+	"strings"
+	"testing"
+)
+
+func TestIsPrivateAddress(t *testing.T) {
+	cases := map[string]bool{
+		"127.0.0.1":          true,
+		"10.1.2.3":           true,
+		"172.16.0.1":         true,
+		"192.168.1.1":        true,
+		"169.254.169.254":    true, // metadata cloud
+		"100.64.0.1":         true, // CGNAT
+		"100.127.255.254":    true,
+		"0.0.0.0":            true,
+		"0.1.2.3":            true,
+		"::1":                true,
+		"fe80::1":            true,
+		"fd00::1":            true,
+		"::ffff:100.64.0.1":  true,
+		"::ffff:127.0.0.1":   true,
+		"8.8.8.8":            false,
+		"100.63.255.255":     false,
+		"100.128.0.0":        false,
+		"2606:4700::1111":    false,
+		"::ffff:203.0.113.9": false,
+	}
+	for addr, want := range cases {
+		if got := isPrivateAddress(net.ParseIP(addr)); got != want {
+			t.Errorf("isPrivateAddress(%s) = %v, se esperaba %v", addr, got, want)
+		}
+	}
+}
+
+func TestValidateSecret(t *testing.T) {
+	if err := validateSecret(strings.Repeat("ñ", maxSecretLength)); err != nil {
+		t.Fatalf("un secreto de %d caracteres debería aceptarse: %v", maxSecretLength, err)
+	}
+	if err := validateSecret(strings.Repeat("a", maxSecretLength+1)); err == nil {
+		t.Fatal("se esperaba error para un secreto demasiado largo")
+	}
+}